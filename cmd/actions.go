@@ -18,16 +18,25 @@ package cmd
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	goErrors "errors"
 	"fmt"
 	"os"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"text/template"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/imdario/mergo"
+	"github.com/manifoldco/promptui"
+	"github.com/moby/term"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 )
 
 // installCmd represents the install command
@@ -57,10 +66,14 @@ func init() {
 	rootCmd.AddCommand(actionsCmd)
 }
 
-// type ActionAnsibleConfig struct {
-// 	Inventory string `yaml:"inventory,omitempty" mapstructure:"inventory,omitempty" json:"inventory,omitempty"`
-// 	Hosts     string `yaml:"hosts,omitempty" mapstructure:"hosts,omitempty" json:"hosts,omitempty"`
-// }
+// Options of playbook actions; --check, --diff, --limit, --tags and --skip-tags take precedence
+type ActionAnsibleConfig struct {
+	Check    bool     `yaml:"check,omitempty" mapstructure:"check,omitempty" json:"check,omitempty"`
+	Diff     bool     `yaml:"diff,omitempty" mapstructure:"diff,omitempty" json:"diff,omitempty"`
+	Limit    string   `yaml:"limit,omitempty" mapstructure:"limit,omitempty" json:"limit,omitempty"`
+	Tags     []string `yaml:"tags,omitempty" mapstructure:"tags,omitempty" json:"tags,omitempty"`
+	SkipTags []string `yaml:"skip_tags,omitempty" mapstructure:"skip_tags,omitempty" json:"skip_tags,omitempty"`
+}
 
 //	type ActionKubernetesConfig struct {
 //		Kubeconfig string `yaml:"kubeconfig,omitempty" mapstructure:"kubeconfig,omitempty" json:"kubeconfig,omitempty"`
@@ -73,16 +86,222 @@ type Action struct {
 	Labels      map[string]string `yaml:"labels,omitempty" mapstructure:"labels,omitempty" json:"labels,omitempty"`
 	Alias       []string          `yaml:"alias,omitempty" mapstructure:"alias,omitempty" json:"alias,omitempty"`
 	Interactive bool              `yaml:"interactive,omitempty" mapstructure:"interactive,omitempty" json:"interactive,omitempty"`
-	Script      []string          `yaml:"script,omitempty" mapstructure:"script,omitempty" json:"script,omitempty" validate:"required_without=Playbook,excluded_with=Playbook"`
-	Playbook    string            `yaml:"playbook,omitempty" mapstructure:"playbook,omitempty" json:"playbook,omitempty" validate:"required_without=Script,excluded_with=Script"`
-	Prompt      Prompt            `yaml:"prompt,omitempty" mapstructure:"prompt,omitempty" json:"prompt,omitempty"`
-	//Ansible             ActionAnsibleConfig    `yaml:"ansible,omitempty" mapstructure:"ansible,omitempty" json:"ansible,omitempty"`
+	Script      []string          `yaml:"script,omitempty" mapstructure:"script,omitempty" json:"script,omitempty" validate:"required_without_all=Playbook Manifests Helm Terraform,excluded_with=Playbook Manifests Helm Terraform"`
+	Playbook    string            `yaml:"playbook,omitempty" mapstructure:"playbook,omitempty" json:"playbook,omitempty" validate:"required_without_all=Script Manifests Helm Terraform,excluded_with=Script Manifests Helm Terraform"`
+	// Directory with Kubernetes manifests (relative to the block) that are applied to the kubeconfig of the block
+	Manifests string `yaml:"manifests,omitempty" mapstructure:"manifests,omitempty" json:"manifests,omitempty" validate:"excluded_with=Script Playbook Helm Terraform"`
+	// Installs/upgrades (`install`) or uninstalls (`uninstall`) the chart configured in the block's config
+	Helm string `yaml:"helm,omitempty" mapstructure:"helm,omitempty" json:"helm,omitempty" validate:"omitempty,oneof=install uninstall,excluded_with=Script Playbook Manifests Terraform"`
+	// Runs terraform (or OpenTofu) `init`, `plan`, `apply` or `destroy` in the workdir of the block
+	// The config of the block is passed as variables, the state is kept in the artifacts of the block
+	Terraform string `yaml:"terraform,omitempty" mapstructure:"terraform,omitempty" json:"terraform,omitempty" validate:"omitempty,oneof=init plan apply destroy,excluded_with=Script Playbook Manifests Helm"`
+	Prompt    Prompt `yaml:"prompt,omitempty" mapstructure:"prompt,omitempty" json:"prompt,omitempty"`
+	// Go template evaluated against the workspace snapshot; the action is skipped if it's false
+	When string `yaml:"when,omitempty" mapstructure:"when,omitempty" json:"when,omitempty"`
+	// Number of times the action is retried after it failed
+	Retries int `yaml:"retries,omitempty" mapstructure:"retries,omitempty" json:"retries,omitempty" validate:"gte=0"`
+	// Delay before the first retry (e.g. 10s); it doubles with every further retry
+	RetryDelay string `yaml:"retry_delay,omitempty" mapstructure:"retry_delay,omitempty" json:"retry_delay,omitempty" validate:"omitempty,duration"`
+	// Maximum duration of a single attempt (e.g. 30m)
+	Timeout string `yaml:"timeout,omitempty" mapstructure:"timeout,omitempty" json:"timeout,omitempty" validate:"omitempty,duration"`
+	// Cron expression (e.g. `0 3 * * *` or `@daily`); `polycrate schedule` runs the action accordingly
+	Schedule string `yaml:"schedule,omitempty" mapstructure:"schedule,omitempty" json:"schedule,omitempty" validate:"omitempty,schedule"`
+	// Actions that must run before this action, either `block:action` or `action` of the same block
+	// A dependency is skipped if nothing changed since its last successful run or its condition isn't met
+	DependsOn []string `yaml:"depends_on,omitempty" mapstructure:"depends_on,omitempty" json:"depends_on,omitempty"`
+	// Actions that run right before and after this action every time, unless they are idempotent
+	Pre  []string `yaml:"pre,omitempty" mapstructure:"pre,omitempty" json:"pre,omitempty"`
+	Post []string `yaml:"post,omitempty" mapstructure:"post,omitempty" json:"post,omitempty"`
+	// Skip the action if neither its block, config, script, image nor inputs changed since its last successful run
+	Idempotent bool `yaml:"idempotent,omitempty" mapstructure:"idempotent,omitempty" json:"idempotent,omitempty"`
+	// Parameters that can be passed to the action (e.g. with `--input key=value`)
+	Inputs  []ActionInput       `yaml:"inputs,omitempty" mapstructure:"inputs,omitempty" json:"inputs,omitempty" validate:"dive"`
+	Ansible ActionAnsibleConfig `yaml:"ansible,omitempty" mapstructure:"ansible,omitempty" json:"ansible,omitempty"`
+	// Overrides the container settings of the block
+	Container ContainerConfig `yaml:"container,omitempty" mapstructure:"container,omitempty" json:"container,omitempty"`
 	//Kubernetes          ActionKubernetesConfig `yaml:"kubernetes,omitempty" mapstructure:"kubernetes,omitempty" json:"kubernetes,omitempty"`
 	executionScriptPath string
 	Block               string                 `yaml:"block,omitempty" mapstructure:"block,omitempty" json:"block,omitempty"`
 	Config              map[string]interface{} `yaml:"config,omitempty" mapstructure:"config,omitempty" json:"config,omitempty"`
 	workspace           *Workspace
 	block               *Block
+	// The script before its templates have been substituted
+	scriptTemplate []string
+}
+
+// Input types
+const (
+	ActionInputTypeString string = "string"
+	ActionInputTypeInt    string = "int"
+	ActionInputTypeFloat  string = "float"
+	ActionInputTypeBool   string = "bool"
+)
+
+// A parameter of an action
+// Resolved inputs are available as `.Inputs` in the snapshot and as POLYCRATE_INPUT_<NAME> env vars
+type ActionInput struct {
+	Name string `yaml:"name,omitempty" mapstructure:"name,omitempty" json:"name,omitempty" validate:"required,metadata_name"`
+	// One of string (default), int, float, bool
+	Type        string   `yaml:"type,omitempty" mapstructure:"type,omitempty" json:"type,omitempty" validate:"omitempty,oneof=string int float bool"`
+	Default     string   `yaml:"default,omitempty" mapstructure:"default,omitempty" json:"default,omitempty"`
+	Required    bool     `yaml:"required,omitempty" mapstructure:"required,omitempty" json:"required,omitempty"`
+	Enum        []string `yaml:"enum,omitempty" mapstructure:"enum,omitempty" json:"enum,omitempty"`
+	Description string   `yaml:"description,omitempty" mapstructure:"description,omitempty" json:"description,omitempty"`
+}
+
+// Converts a value to the type of the input
+func (i *ActionInput) parse(value string) (interface{}, error) {
+	if len(i.Enum) > 0 {
+		valid := false
+		for _, option := range i.Enum {
+			if value == option {
+				valid = true
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("input '%s' must be one of %s, got '%s'", i.Name, strings.Join(i.Enum, ", "), value)
+		}
+	}
+
+	var parsed interface{}
+	var err error
+	switch i.Type {
+	case ActionInputTypeInt:
+		parsed, err = strconv.Atoi(value)
+	case ActionInputTypeFloat:
+		parsed, err = strconv.ParseFloat(value, 64)
+	case ActionInputTypeBool:
+		parsed, err = strconv.ParseBool(value)
+	default:
+		parsed = value
+	}
+	if err != nil {
+		return nil, fmt.Errorf("input '%s' must be of type %s, got '%s'", i.Name, i.Type, value)
+	}
+	return parsed, nil
+}
+
+// Asks the user for the value of the input
+func (i *ActionInput) prompt() (string, error) {
+	promptLock.Lock()
+	defer promptLock.Unlock()
+
+	label := i.Name
+	if i.Description != "" {
+		label = fmt.Sprintf("%s (%s)", i.Name, i.Description)
+	}
+
+	if len(i.Enum) > 0 {
+		prompt := promptui.Select{
+			Label: label,
+			Items: i.Enum,
+		}
+		_, result, err := prompt.Run()
+		return result, err
+	}
+
+	prompt := promptui.Prompt{
+		Label: label,
+		Validate: func(value string) error {
+			_, err := i.parse(value)
+			return err
+		},
+	}
+	return prompt.Run()
+}
+
+// Returns the name of the env var that holds the value of the input
+func (i *ActionInput) getEnvVar() string {
+	name := strings.NewReplacer("-", "_", "/", "_").Replace(i.Name)
+	return "POLYCRATE_INPUT_" + strings.ToUpper(name)
+}
+
+func (a *Action) GetInput(name string) (*ActionInput, error) {
+	for i := 0; i < len(a.Inputs); i++ {
+		if a.Inputs[i].Name == name {
+			return &a.Inputs[i], nil
+		}
+	}
+	return nil, fmt.Errorf("action '%s' has no input '%s'", a.Name, name)
+}
+
+// Resolves the given values for the inputs of the action and converts them to the input types
+// Inputs without a value fall back to their default
+// Required inputs that still have no value are prompted for if possible and allowed
+func (a *Action) ResolveInputs(values map[string]string, allowPrompt bool) (map[string]interface{}, error) {
+	for name := range values {
+		if _, err := a.GetInput(name); err != nil {
+			return nil, err
+		}
+	}
+
+	// Prompts need a terminal and are skipped with --force
+	_, isTerm := term.GetFdInfo(os.Stdin)
+	allowPrompt = allowPrompt && isTerm && !force
+
+	inputs := map[string]interface{}{}
+	missing := []string{}
+	for i := range a.Inputs {
+		input := &a.Inputs[i]
+
+		value, ok := values[input.Name]
+		if !ok && input.Default != "" {
+			value, ok = input.Default, true
+		}
+		if !ok && input.Required && allowPrompt {
+			var err error
+			if value, err = input.prompt(); err != nil {
+				return nil, err
+			}
+			ok = true
+		}
+		if !ok {
+			if input.Required {
+				missing = append(missing, input.Name)
+			}
+			continue
+		}
+
+		parsed, err := input.parse(value)
+		if err != nil {
+			return nil, err
+		}
+		inputs[input.Name] = parsed
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("action '%s' is missing required input(s) %s. Set them with --input key=value", a.Name, strings.Join(missing, ", "))
+	}
+	return inputs, nil
+}
+
+// Parses `key=value` pairs (e.g. from --input) into a map
+func ParseInputs(values []string) (map[string]string, error) {
+	inputs := map[string]string{}
+	for _, value := range values {
+		// Split by the first =
+		p := strings.SplitN(value, "=", 2)
+		if len(p) != 2 || p[0] == "" {
+			return nil, fmt.Errorf("illegal value for input found: %s. Use key=value", value)
+		}
+		inputs[p[0]] = p[1]
+	}
+	return inputs, nil
+}
+
+// Substitutes the templates in the script of the action
+// The original script is kept, so the script can be rendered again with the snapshot of the run
+func (a *Action) templateScript(snapshot WorkspaceSnapshot) error {
+	if a.scriptTemplate == nil {
+		a.scriptTemplate = a.Script
+	}
+
+	script, err := renderTemplates(a.scriptTemplate, snapshot)
+	if err != nil {
+		return err
+	}
+	a.Script = script
+	return nil
 }
 
 func (c *Action) MergeIn(action Action) error {
@@ -290,12 +509,57 @@ func (c *Action) MergeIn(action Action) error {
 // 	return err
 // }
 
-func (a *Action) Run(tx *PolycrateTransaction) error {
+// Runs the action; a dependency of another action is skipped like an idempotent action if it's up to date
+func (a *Action) Run(tx *PolycrateTransaction, dependency bool) error {
 
 	polycrate.WaitForGracefulShutdown()
 
 	tx.Log.Infof("Running action")
 
+	block := a.block
+	workspace := block.workspace
+
+	// Resolve the inputs before anything is started
+	inputs, err := a.ResolveInputs(workspace.inputs, true)
+	if err != nil {
+		return err
+	}
+	workspace.registerCurrentInputs(a, inputs)
+
+	// Check if a condition is configured and evaluate it
+	if a.When != "" {
+		result, err := workspace.GetSnapshot().EvaluateCondition(a.When)
+		if err != nil {
+			return fmt.Errorf("failed to evaluate condition of action '%s': %s", a.Name, err)
+		}
+		if !result {
+			tx.Log.Infof("Not running action. Condition not met: %s", a.When)
+			return ErrConditionNotMet
+		}
+	}
+
+	// Skip the action if nothing changed since its last successful run
+	cacheKey, err := a.getCacheKey(inputs)
+	if err != nil {
+		return err
+	}
+	if (a.Idempotent || dependency || skipUnchanged) && !snapshot {
+		if cached := workspace.getLastCachedRun(a); cached != nil && cached.Key == cacheKey {
+			tx.Log.Infof("Not running action. Up to date since %s (transaction %s)", cached.Date, cached.Transaction)
+
+			// The outputs are those of the cached run
+			tx.SetOutputs(cached.Outputs)
+			tx.SetCacheKey(a.getAddress(), cacheKey)
+			return nil
+		}
+	}
+
+	// Scripts can't be dry-run
+	if checkMode && (len(a.Script) > 0 || a.Helm != "") {
+		tx.Log.Infof("Not running action in check mode")
+		return ErrCheckModeNotSupported
+	}
+
 	// Check if a prompt is configured and execute it
 	if a.Prompt.Message != "" {
 		result := a.Prompt.Validate()
@@ -304,23 +568,14 @@ func (a *Action) Run(tx *PolycrateTransaction) error {
 		}
 	}
 
-	block := a.block
-	workspace := block.workspace
-
 	tx.Log.Debugf("Running action")
 
-	// 3. Determine inventory path
-	inventoryPath := block.getInventoryPath(tx)
-	workspace.registerEnvVar("ANSIBLE_INVENTORY", inventoryPath)
-	tx.Log.Tracef("Updating inventory: %s", inventoryPath)
-
-	// 4. Determine kubeconfig path
-	kubeconfigPath := block.getKubeconfigPath(tx)
-	workspace.registerEnvVar("KUBECONFIG", kubeconfigPath)
-	tx.Log.Tracef("Updating kubeconfig: %s", kubeconfigPath)
+	a.registerEnvVars(tx)
 
-	// register environment variables
-	workspace.registerEnvVar("POLYCRATE_RUNTIME_SCRIPT_PATH", a.executionScriptPath)
+	// Render the script again, now that the inputs and the current step are known
+	if err := a.templateScript(workspace.GetSnapshot()); err != nil {
+		return err
+	}
 
 	// Wrapup
 	if a.Interactive {
@@ -335,6 +590,33 @@ func (a *Action) Run(tx *PolycrateTransaction) error {
 		if snapshotPath, err := workspace.SaveSnapshot(tx); err != nil {
 			return err
 		} else {
+			// Manifests are applied by polycrate itself instead of a container
+			if a.Manifests != "" {
+				err := tx.RunWithRetries(fmt.Sprintf("action %s:%s", block.Name, a.Name), a.Retries, a.RetryDelay, a.Timeout, func(ctx context.Context, attempt int) error {
+					return a.applyManifests(ctx, tx)
+				})
+				if err == nil && !checkMode {
+					workspace.saveCachedRun(tx, a, cacheKey)
+				}
+				return err
+			}
+
+			// Terraform actions run in stages that need a confirmation in between
+			if a.Terraform != "" {
+				err := a.runTerraform(tx)
+
+				outputs, outputErr := workspace.readOutput()
+				if outputErr != nil {
+					tx.Log.Warnf("Failed to read outputs of action: %s", outputErr)
+				}
+				tx.SetOutputs(outputs)
+
+				if err == nil && !checkMode {
+					workspace.saveCachedRun(tx, a, cacheKey)
+				}
+				return err
+			}
+
 			// Save execution script
 			var err error
 			if len(a.Script) > 0 {
@@ -343,6 +625,8 @@ func (a *Action) Run(tx *PolycrateTransaction) error {
 				workspace.registerEnvVar("ANSIBLE_VARS_ENABLED", "polycrate_vars")
 			} else if a.Playbook != "" {
 				err = a.saveAnsibleScript(tx, snapshotPath)
+			} else if a.Helm != "" {
+				err = a.saveHelmScript(tx)
 			} else {
 				err = fmt.Errorf("neither 'script' nor 'playbook' have been defined")
 			}
@@ -353,45 +637,179 @@ func (a *Action) Run(tx *PolycrateTransaction) error {
 
 			// register mounts
 			workspace.registerMount(a.executionScriptPath, a.executionScriptPath)
+			workspace.registerMount(workspace.getOutputPath(), workspace.getOutputPath())
+			if a.Playbook != "" {
+				workspace.registerMount(workspace.getPlaybookLogPath(), workspace.getPlaybookLogPath())
+			}
 
-			if !local {
-				containerName := tx.TXID.String()
-
-				runCommand := []string{}
-				if a.executionScriptPath != "" {
-					tx.Log.Debugf("Running script: %s", a.executionScriptPath)
+			err = tx.RunWithRetries(fmt.Sprintf("action %s:%s", block.Name, a.Name), a.Retries, a.RetryDelay, a.Timeout, func(ctx context.Context, attempt int) error {
+				return a.execute(ctx, tx)
+			})
 
-					runCommand = append(runCommand, a.executionScriptPath)
-				} else {
-					return goErrors.New("no execution script path given. Nothing to do")
+			// Collect the outputs of the action
+			outputs, outputErr := workspace.readOutput()
+			if outputErr != nil {
+				tx.Log.Warnf("Failed to read outputs of action: %s", outputErr)
+			}
+			if a.Playbook != "" {
+				if playbookLog, logErr := os.ReadFile(workspace.getPlaybookLogPath()); logErr != nil {
+					tx.Log.Warnf("Failed to read play recap of action: %s", logErr)
+				} else if recap := parsePlayRecap(string(playbookLog)); recap != nil {
+					if outputs == nil {
+						outputs = map[string]string{}
+					}
+					for k, v := range recap {
+						outputs[k] = v
+					}
 				}
+			}
+			tx.SetOutputs(outputs)
 
-				err = workspace.RunContainer(tx, containerName, block.Workdir.Path, runCommand)
-				if err != nil {
-					return err
-				}
-			} else {
-				args := []string{"-c"}
-				if a.executionScriptPath != "" {
-					tx.Log.Debugf("Running script: %s", a.executionScriptPath)
+			if err == nil && !checkMode {
+				workspace.saveCachedRun(tx, a, cacheKey)
+			}
+			return err
+		}
+	}
+	return nil
+}
 
-					args = append(args, a.executionScriptPath)
-				} else {
-					return goErrors.New("no execution script path given. Nothing to do")
-				}
-				exitCode, output, err := RunCommand(tx.Context, workspace.DumpEnv(), "/bin/bash", args...)
-				if err != nil {
-					fmt.Println(output)
-					return err
-				}
+// Returns the address of the action (block:action)
+func (a *Action) getAddress() string {
+	return strings.Join([]string{a.Block, a.Name}, ":")
+}
+
+// Returns the container settings of the action
+// Settings of the action override those of the block, mounts are added up
+// A dockerfile is resolved relative to the block
+func (a *Action) getContainerConfig() ContainerConfig {
+	config := a.block.Container
+	config.Mounts = append([]string{}, a.block.Container.Mounts...)
+
+	if a.Container.Image != "" || a.Container.Dockerfile != "" {
+		config.Image = a.Container.Image
+		config.Dockerfile = a.Container.Dockerfile
+	}
+	if a.Container.Cpus != "" {
+		config.Cpus = a.Container.Cpus
+	}
+	if a.Container.Memory != "" {
+		config.Memory = a.Container.Memory
+	}
+	if a.Container.User != "" {
+		config.User = a.Container.User
+	}
+	if a.Container.Network != "" {
+		config.Network = a.Container.Network
+	}
+	config.Mounts = append(config.Mounts, a.Container.Mounts...)
+
+	config.context = a.block.Workdir.LocalPath
+	return config
+}
+
+// Returns a key that changes whenever the block, its config, the definition of the action,
+// the image or the inputs change
+func (a *Action) getCacheKey(inputs map[string]interface{}) (string, error) {
+	block := a.block
+
+	script := a.scriptTemplate
+	if script == nil {
+		script = a.Script
+	}
+
+	data, err := yaml.Marshal(map[string]interface{}{
+		"checksum":  block.Checksum,
+		"config":    block.Config,
+		"script":    script,
+		"playbook":  a.Playbook,
+		"manifests": a.Manifests,
+		"helm":      a.Helm,
+		"terraform": a.Terraform,
+		"ansible":   a.getAnsibleConfig(),
+		"image":     block.workspace.getContainerImage(a.getContainerConfig()),
+		"container": a.getContainerConfig(),
+		"inputs":    inputs,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// Registers the env vars that depend on the action and its block
+func (a *Action) registerEnvVars(tx *PolycrateTransaction) {
+	block := a.block
+	workspace := block.workspace
+
+	// 3. Determine inventory path
+	inventoryPath := block.getInventoryPath(tx)
+	workspace.registerEnvVar("ANSIBLE_INVENTORY", inventoryPath)
+	tx.Log.Tracef("Updating inventory: %s", inventoryPath)
+
+	// 4. Determine kubeconfig path
+	kubeconfigPath := block.getKubeconfigPath(tx)
+	workspace.registerEnvVar("KUBECONFIG", kubeconfigPath)
+	tx.Log.Tracef("Updating kubeconfig: %s", kubeconfigPath)
+
+	// register environment variables
+	workspace.registerEnvVar("POLYCRATE_RUNTIME_SCRIPT_PATH", a.executionScriptPath)
+}
+
+// Runs the execution script of the action in a container or locally
+// The script is interrupted when ctx is cancelled, e.g. by the timeout of the attempt
+func (a *Action) execute(ctx context.Context, tx *PolycrateTransaction) error {
+	block := a.block
+	workspace := block.workspace
+
+	// Start every attempt with an empty output file
+	err := workspace.resetOutput()
+	if err != nil {
+		return err
+	}
 
-				tx.SetExitCode(exitCode)
-				tx.SetOutput(output)
+	if !local {
+		containerName := tx.NewContainerName()
 
-				//err := fmt.Errorf("'local' mode not yet implemented")
-				//return ctx, err
+		runCommand := []string{}
+		if a.executionScriptPath != "" {
+			tx.Log.Debugf("Running script: %s", a.executionScriptPath)
+
+			runCommand = append(runCommand, a.executionScriptPath)
+		} else {
+			return goErrors.New("no execution script path given. Nothing to do")
+		}
+
+		err = workspace.RunContainer(ctx, tx, containerName, block.Workdir.Path, runCommand, a.getContainerConfig())
+		if err != nil {
+			// The container keeps running if the attempt has been interrupted, e.g. by a timeout
+			if ctx.Err() != nil {
+				_ = RemoveContainer(tx, containerName)
 			}
+			return err
+		}
+	} else {
+		args := []string{"-c"}
+		if a.executionScriptPath != "" {
+			tx.Log.Debugf("Running script: %s", a.executionScriptPath)
+
+			args = append(args, a.executionScriptPath)
+		} else {
+			return goErrors.New("no execution script path given. Nothing to do")
 		}
+		exitCode, output, err := RunCommand(ctx, workspace.DumpEnv(), "/bin/bash", args...)
+		tx.SetExitCode(exitCode)
+		if err != nil {
+			fmt.Println(output)
+			return err
+		}
+
+		tx.SetOutput(output)
+
+		//err := fmt.Errorf("'local' mode not yet implemented")
+		//return ctx, err
 	}
 	return nil
 }
@@ -459,19 +877,12 @@ func (c *Action) SaveExecutionScript(tx *PolycrateTransaction) error {
 }
 
 func (a *Action) saveAnsibleScript(tx *PolycrateTransaction, snapshotContainerPath string) error {
-	// Prepare script
-	scriptSlice := []string{
-		"#!/bin/bash",
-		"set -euo pipefail",
-		"trap exit SIGINT",
-		"trap exit SIGTERM",
-		"trap exit SIGKILL",
-	}
-
-	scriptString := fmt.Sprintf("ansible-playbook -e '@%s' %s &", snapshotContainerPath, a.Playbook)
-	script := append(scriptSlice, scriptString, "wait $!")
+	return a.saveScript(tx, a.getPlaybookScript(snapshotContainerPath))
+}
 
-	snapshot := workspace.GetSnapshot()
+// Templates the script with the workspace snapshot and saves it as the execution script of the action
+func (a *Action) saveScript(tx *PolycrateTransaction, script []string) error {
+	snapshot := a.block.workspace.GetSnapshot()
 
 	scriptSlug := slugify([]string{tx.TXID.String(), "execution", "script"})
 	scriptFilename := strings.Join([]string{scriptSlug, "sh"}, ".")
@@ -522,9 +933,153 @@ func (a *Action) saveAnsibleScript(tx *PolycrateTransaction, snapshotContainerPa
 		a.executionScriptPath = f.Name()
 		return nil
 	} else {
-		return fmt.Errorf("ansible: 'script' section of action is empty")
+		return fmt.Errorf("'script' section of action is empty")
+	}
+
+}
+
+// Returns the script that runs the playbook of the action with the snapshot as extra vars
+func (a *Action) getPlaybookScript(snapshotContainerPath string) []string {
+	// Prepare script
+	scriptSlice := []string{
+		"#!/bin/bash",
+		"set -euo pipefail",
+		"trap exit SIGINT",
+		"trap exit SIGTERM",
+		"trap exit SIGKILL",
+	}
+
+	command := []string{"ansible-playbook", "-e", fmt.Sprintf("'@%s'", snapshotContainerPath), a.Playbook}
+	command = append(command, a.getAnsibleConfig().getArgs()...)
+
+	return append(scriptSlice,
+		// The play recap is read from the log of the playbook. A log path configured
+		// in the environment or in ansible.cfg is kept, otherwise a temporary log is used
+		`log_path="${ANSIBLE_LOG_PATH:-$(ansible-config dump --only-changed 2>/dev/null | sed -n 's/^DEFAULT_LOG_PATH([^)]*) = //p' || true)}"`,
+		`log_path="${log_path/#\~/$HOME}"`,
+		`if [ -z "$log_path" ]; then`,
+		`  log_path="$(mktemp)"`,
+		`  trap 'rm -f "$log_path"' EXIT`,
+		`  export ANSIBLE_LOG_PATH="$log_path"`,
+		`fi`,
+		// Only the part of the log written by this run is handed over
+		`log_offset=$(( $([ -f "$log_path" ] && wc -c < "$log_path" || echo 0) + 1 ))`,
+		strings.Join(command, " ")+" &",
+		"exit_code=0",
+		"wait $! || exit_code=$?",
+		fmt.Sprintf(`tail -c "+$log_offset" "$log_path" > %s || true`, shellQuote(workspace.getPlaybookLogPath())),
+		"exit $exit_code",
+	)
+}
+
+var playRecapPattern = regexp.MustCompile(`(\S+)\s+:\s+((?:[a-z]+=\d+\s*)+)$`)
+
+// Sums up the play recap of all hosts in the given playbook log.
+// Returns ansible_<counter> for each counter and the hosts with changes
+// as ansible_changed_hosts
+func parsePlayRecap(playbookLog string) map[string]string {
+	sums := map[string]int{}
+	changedHosts := []string{}
+	recap := false
+	for _, line := range strings.Split(playbookLog, "\n") {
+		if strings.Contains(line, "PLAY RECAP") {
+			recap = true
+			continue
+		}
+		if !recap {
+			continue
+		}
+
+		match := playRecapPattern.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		for _, counter := range strings.Fields(match[2]) {
+			kv := strings.SplitN(counter, "=", 2)
+			switch kv[0] {
+			case "ok", "changed", "unreachable", "failed", "skipped", "rescued", "ignored":
+				value, _ := strconv.Atoi(kv[1])
+				sums[kv[0]] += value
+				if kv[0] == "changed" && value > 0 && !slices.Contains(changedHosts, match[1]) {
+					changedHosts = append(changedHosts, match[1])
+				}
+			}
+		}
+	}
+
+	if len(sums) == 0 {
+		return nil
+	}
+	outputs := map[string]string{
+		"ansible_changed_hosts": strings.Join(changedHosts, ","),
+	}
+	for counter, sum := range sums {
+		outputs["ansible_"+counter] = strconv.Itoa(sum)
+	}
+	return outputs
+}
+
+// Returns the ansible config of the action with the command line flags applied
+func (a *Action) getAnsibleConfig() ActionAnsibleConfig {
+	config := a.Ansible
+	config.Check = config.Check || checkMode
+	config.Diff = config.Diff || diffMode
+	if playbookLimit != "" {
+		config.Limit = playbookLimit
+	}
+	if len(playbookTags) > 0 {
+		config.Tags = playbookTags
+	}
+	if len(playbookSkipTags) > 0 {
+		config.SkipTags = playbookSkipTags
+	}
+	return config
+}
+
+// Returns the arguments for ansible-playbook; values are quoted for the shell
+func (c ActionAnsibleConfig) getArgs() []string {
+	args := []string{}
+	if c.Check {
+		args = append(args, "--check")
+	}
+	if c.Diff {
+		args = append(args, "--diff")
+	}
+	if c.Limit != "" {
+		args = append(args, "--limit", shellQuote(c.Limit))
+	}
+	if len(c.Tags) > 0 {
+		args = append(args, "--tags", shellQuote(strings.Join(c.Tags, ",")))
+	}
+	if len(c.SkipTags) > 0 {
+		args = append(args, "--skip-tags", shellQuote(strings.Join(c.SkipTags, ",")))
+	}
+	return args
+}
+
+// Returns the execution script of the action with all templates substituted
+// This is what SaveExecutionScript and saveAnsibleScript write to disk
+func (a *Action) RenderExecutionScript(snapshot WorkspaceSnapshot, snapshotContainerPath string) ([]string, error) {
+	var script []string
+	if len(a.Script) > 0 {
+		script = a.GetExecutionScript()
+	} else if a.Playbook != "" {
+		script = a.getPlaybookScript(snapshotContainerPath)
+	} else if a.Helm != "" {
+		var err error
+		if script, err = a.getHelmScript(); err != nil {
+			return nil, err
+		}
+	} else if a.Terraform != "" {
+		script = a.getTerraformScript(a.getTerraformStages()...)
+	} else if a.Manifests != "" {
+		// Manifests are applied without a script
+		return nil, nil
+	} else {
+		return nil, fmt.Errorf("neither 'script' nor 'playbook' have been defined")
 	}
 
+	return renderTemplates(script, snapshot)
 }
 
 func (c *Action) GetExecutionScript() []string {
@@ -597,6 +1152,23 @@ func (a *Action) validate() error {
 		// from here you can create your own error messages in whatever language you wish
 		return fmt.Errorf("error validating Action '%s' of Block '%s'", a.Name, a.Block)
 	}
+
+	// Check the inputs for duplicates and defaults that don't match their type
+	names := map[string]bool{}
+	for i := range a.Inputs {
+		input := &a.Inputs[i]
+		if names[input.Name] {
+			return fmt.Errorf("action '%s' of Block '%s' has more than one input named '%s'", a.Name, a.Block, input.Name)
+		}
+		names[input.Name] = true
+
+		if input.Default != "" {
+			if _, err := input.parse(input.Default); err != nil {
+				return fmt.Errorf("invalid default of action '%s' of Block '%s': %s", a.Name, a.Block, err)
+			}
+		}
+	}
+
 	// if a.Script == nil {
 	// 	return goErrors.New("no script found for Action")
 	// }
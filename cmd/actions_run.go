@@ -16,9 +16,27 @@ limitations under the License.
 package cmd
 
 import (
+	goErrors "errors"
+
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
+var actionInputs []string
+var skipUnchanged bool
+
+// Wait for the lock of the block instead of failing if it's held
+var waitForLock bool
+
+// Check and diff mode of playbook and manifests actions
+var checkMode bool
+var diffMode bool
+
+// Limit and tags of playbook actions
+var playbookLimit string
+var playbookTags []string
+var playbookSkipTags []string
+
 // installCmd represents the install command
 var actionsRunCmd = &cobra.Command{
 	Use:   "run $BLOCK $ACTION'",
@@ -36,13 +54,33 @@ var actionsRunCmd = &cobra.Command{
 			tx.Log.Fatal(err)
 		}
 
-		err = workspace.RunAction(tx, args[0], args[1])
+		inputs, err := ParseInputs(actionInputs)
 		if err != nil {
+			tx.Log.Fatal(err)
+		}
+		workspace.SetInputs(inputs)
+
+		err = workspace.RunAction(tx, args[0], args[1])
+		if err != nil && !goErrors.Is(err, ErrConditionNotMet) {
 			tx.Log.Fatalf("Error running action: %s", err)
 		}
 	},
 }
 
+// Adds the flags for running actions; they're shared by `run` and `actions run`
+func addActionRunFlags(fs *pflag.FlagSet) {
+	fs.StringArrayVar(&actionInputs, "input", []string{}, "Value for an input of the action in the format 'key=value'. Can be given multiple times")
+	fs.BoolVar(&waitForLock, "wait", false, "Wait for actions of the same block running in other processes instead of failing")
+	fs.BoolVar(&skipUnchanged, "skip-unchanged", false, "Don't run actions that haven't changed since their last successful run")
+	fs.BoolVar(&checkMode, "check", false, "Only show what would change: playbooks run in check mode, manifests are not applied and scripts are not run")
+	fs.BoolVar(&diffMode, "diff", false, "Show the changes playbooks make")
+	fs.StringVar(&playbookLimit, "limit", "", "Limit playbooks to the given hosts pattern")
+	fs.StringSliceVar(&playbookTags, "tags", []string{}, "Only run the tasks of playbooks with these tags")
+	fs.StringSliceVar(&playbookSkipTags, "skip-tags", []string{}, "Skip the tasks of playbooks with these tags")
+}
+
 func init() {
+	addActionRunFlags(actionsRunCmd.Flags())
+
 	actionsCmd.AddCommand(actionsRunCmd)
 }
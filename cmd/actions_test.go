@@ -0,0 +1,393 @@
+/*
+Copyright © 2021 Fabian Peter <fp@ayedo.de>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseInputs(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []string
+		expected map[string]string
+		err      bool
+	}{
+		{
+			name:     "no inputs",
+			values:   []string{},
+			expected: map[string]string{},
+		},
+		{
+			name:   "key value pairs",
+			values: []string{"replicas=3", "env=production"},
+			expected: map[string]string{
+				"replicas": "3",
+				"env":      "production",
+			},
+		},
+		{
+			name:     "values are split at the first separator",
+			values:   []string{"query=a=b&c=d"},
+			expected: map[string]string{"query": "a=b&c=d"},
+		},
+		{
+			name:     "empty value",
+			values:   []string{"env="},
+			expected: map[string]string{"env": ""},
+		},
+		{
+			name:     "later values override earlier ones",
+			values:   []string{"env=staging", "env=production"},
+			expected: map[string]string{"env": "production"},
+		},
+		{
+			name:   "value without separator",
+			values: []string{"env"},
+			err:    true,
+		},
+		{
+			name:   "value without key",
+			values: []string{"=production"},
+			err:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inputs, err := ParseInputs(tt.values)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", inputs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(inputs, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, inputs)
+			}
+		})
+	}
+}
+
+func TestActionInputParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    ActionInput
+		value    string
+		expected interface{}
+		err      bool
+	}{
+		{name: "string by default", input: ActionInput{Name: "env"}, value: "production", expected: "production"},
+		{name: "explicit string", input: ActionInput{Name: "env", Type: ActionInputTypeString}, value: "42", expected: "42"},
+		{name: "int", input: ActionInput{Name: "replicas", Type: ActionInputTypeInt}, value: "3", expected: 3},
+		{name: "negative int", input: ActionInput{Name: "offset", Type: ActionInputTypeInt}, value: "-1", expected: -1},
+		{name: "invalid int", input: ActionInput{Name: "replicas", Type: ActionInputTypeInt}, value: "three", err: true},
+		{name: "float as int", input: ActionInput{Name: "replicas", Type: ActionInputTypeInt}, value: "1.5", err: true},
+		{name: "float", input: ActionInput{Name: "ratio", Type: ActionInputTypeFloat}, value: "0.5", expected: 0.5},
+		{name: "int as float", input: ActionInput{Name: "ratio", Type: ActionInputTypeFloat}, value: "2", expected: 2.0},
+		{name: "invalid float", input: ActionInput{Name: "ratio", Type: ActionInputTypeFloat}, value: "half", err: true},
+		{name: "bool", input: ActionInput{Name: "debug", Type: ActionInputTypeBool}, value: "true", expected: true},
+		{name: "bool from number", input: ActionInput{Name: "debug", Type: ActionInputTypeBool}, value: "0", expected: false},
+		{name: "invalid bool", input: ActionInput{Name: "debug", Type: ActionInputTypeBool}, value: "yes", err: true},
+		{
+			name:     "enum option",
+			input:    ActionInput{Name: "env", Enum: []string{"staging", "production"}},
+			value:    "staging",
+			expected: "staging",
+		},
+		{
+			name:  "value not in enum",
+			input: ActionInput{Name: "env", Enum: []string{"staging", "production"}},
+			value: "development",
+			err:   true,
+		},
+		{
+			name:     "typed enum",
+			input:    ActionInput{Name: "replicas", Type: ActionInputTypeInt, Enum: []string{"1", "3"}},
+			value:    "3",
+			expected: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := tt.input.parse(tt.value)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", parsed)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if parsed != tt.expected {
+				t.Errorf("expected %#v, got %#v", tt.expected, parsed)
+			}
+		})
+	}
+}
+
+func TestResolveInputs(t *testing.T) {
+	action := &Action{Name: "deploy", Inputs: []ActionInput{
+		{Name: "env", Required: true},
+		{Name: "replicas", Type: ActionInputTypeInt, Default: "1"},
+		{Name: "debug", Type: ActionInputTypeBool},
+	}}
+
+	tests := []struct {
+		name     string
+		values   map[string]string
+		expected map[string]interface{}
+		err      bool
+	}{
+		{
+			name:     "defaults are applied",
+			values:   map[string]string{"env": "production"},
+			expected: map[string]interface{}{"env": "production", "replicas": 1},
+		},
+		{
+			name:     "values override defaults",
+			values:   map[string]string{"env": "production", "replicas": "3", "debug": "true"},
+			expected: map[string]interface{}{"env": "production", "replicas": 3, "debug": true},
+		},
+		{
+			name:   "missing required input",
+			values: map[string]string{"replicas": "3"},
+			err:    true,
+		},
+		{
+			name:   "unknown input",
+			values: map[string]string{"env": "production", "region": "eu"},
+			err:    true,
+		},
+		{
+			name:   "invalid value",
+			values: map[string]string{"env": "production", "replicas": "many"},
+			err:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inputs, err := action.ResolveInputs(tt.values, false)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", inputs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(inputs, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, inputs)
+			}
+		})
+	}
+}
+
+// Returns an action of a block in a workspace, as the workspace loader links them
+func newTestAction() *Action {
+	workspace := &Workspace{Name: "ws"}
+	workspace.Config.Image.Reference = "cargo.ayedo.cloud/library/polycrate"
+	workspace.Config.Image.Version = "0.1.0"
+
+	block := &Block{
+		Name:     "app",
+		Checksum: "abc",
+		Config: map[interface{}]interface{}{
+			"env":      "production",
+			"replicas": 3,
+			"image":    map[interface{}]interface{}{"repository": "app", "tag": "1.0"},
+		},
+		workspace: workspace,
+	}
+	action := &Action{
+		Name:   "deploy",
+		Block:  "app",
+		Script: []string{"echo {{ .Block.Config.env }}"},
+		block:  block,
+	}
+	block.Actions = []*Action{action}
+	return action
+}
+
+func TestGetCacheKey(t *testing.T) {
+	inputs := map[string]interface{}{"replicas": 3, "debug": true, "env": "production"}
+
+	base, err := newTestAction().getCacheKey(inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The key doesn't depend on the order of maps or on rendering the script
+	for i := 0; i < 20; i++ {
+		action := newTestAction()
+		if err := action.templateScript(WorkspaceSnapshot{Block: action.block}); err != nil {
+			t.Fatal(err)
+		}
+		key, err := action.getCacheKey(map[string]interface{}{"env": "production", "debug": true, "replicas": 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != base {
+			t.Fatalf("expected stable key %s, got %s", base, key)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(action *Action, inputs map[string]interface{})
+	}{
+		{"block checksum", func(a *Action, _ map[string]interface{}) { a.block.Checksum = "def" }},
+		{"block config", func(a *Action, _ map[string]interface{}) { a.block.Config["replicas"] = 4 }},
+		{"nested block config", func(a *Action, _ map[string]interface{}) {
+			a.block.Config["image"] = map[interface{}]interface{}{"repository": "app", "tag": "1.1"}
+		}},
+		{"script", func(a *Action, _ map[string]interface{}) { a.Script = []string{"echo changed"} }},
+		{"playbook", func(a *Action, _ map[string]interface{}) { a.Playbook = "site.yml" }},
+		{"ansible settings", func(a *Action, _ map[string]interface{}) { a.Ansible.Limit = "web" }},
+		{"workspace image", func(a *Action, _ map[string]interface{}) { a.block.workspace.Config.Image.Version = "0.2.0" }},
+		{"action image", func(a *Action, _ map[string]interface{}) { a.Container.Image = "alpine:3" }},
+		{"container mounts", func(a *Action, _ map[string]interface{}) { a.Container.Mounts = []string{"/tmp:/tmp"} }},
+		{"input value", func(_ *Action, inputs map[string]interface{}) { inputs["replicas"] = 4 }},
+		{"input type", func(_ *Action, inputs map[string]interface{}) { inputs["replicas"] = "3" }},
+		{"additional input", func(_ *Action, inputs map[string]interface{}) { inputs["region"] = "eu" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action := newTestAction()
+			changed := map[string]interface{}{"replicas": 3, "debug": true, "env": "production"}
+			tt.change(action, changed)
+
+			key, err := action.getCacheKey(changed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key == base {
+				t.Errorf("expected the key to change")
+			}
+		})
+	}
+}
+
+func TestAnsibleConfigGetArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   ActionAnsibleConfig
+		expected []string
+	}{
+		{
+			name:     "no settings",
+			config:   ActionAnsibleConfig{},
+			expected: []string{},
+		},
+		{
+			name:     "check and diff",
+			config:   ActionAnsibleConfig{Check: true, Diff: true},
+			expected: []string{"--check", "--diff"},
+		},
+		{
+			name:     "limit",
+			config:   ActionAnsibleConfig{Limit: "web:&prod"},
+			expected: []string{"--limit", "'web:&prod'"},
+		},
+		{
+			name:     "tags and skip tags",
+			config:   ActionAnsibleConfig{Tags: []string{"install", "configure"}, SkipTags: []string{"debug"}},
+			expected: []string{"--tags", "'install,configure'", "--skip-tags", "'debug'"},
+		},
+		{
+			name:     "values with quotes",
+			config:   ActionAnsibleConfig{Limit: "it's; rm -rf /"},
+			expected: []string{"--limit", `'it'"'"'s; rm -rf /'`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if args := tt.config.getArgs(); !reflect.DeepEqual(args, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, args)
+			}
+		})
+	}
+}
+
+func TestParsePlayRecap(t *testing.T) {
+	tests := []struct {
+		name     string
+		log      string
+		expected map[string]string
+	}{
+		{
+			name:     "no recap",
+			log:      "PLAY [all] ****\nTASK [ping] ****\nok: [web1]\n",
+			expected: nil,
+		},
+		{
+			name: "single host",
+			log: "TASK [ping] ****\nok: [web1]\n\nPLAY RECAP ****\n" +
+				"web1 : ok=2    changed=0    unreachable=0    failed=0    skipped=1    rescued=0    ignored=0\n",
+			expected: map[string]string{
+				"ansible_ok":            "2",
+				"ansible_changed":       "0",
+				"ansible_unreachable":   "0",
+				"ansible_failed":        "0",
+				"ansible_skipped":       "1",
+				"ansible_rescued":       "0",
+				"ansible_ignored":       "0",
+				"ansible_changed_hosts": "",
+			},
+		},
+		{
+			name: "multiple hosts with log prefix",
+			log: "2026-10-18 10:00:00,000 p=12 u=root n=ansible | PLAY RECAP ****\n" +
+				"2026-10-18 10:00:00,001 p=12 u=root n=ansible | web1 : ok=3 changed=1 unreachable=0 failed=0\n" +
+				"2026-10-18 10:00:00,002 p=12 u=root n=ansible | web2 : ok=2 changed=0 unreachable=1 failed=0\n" +
+				"2026-10-18 10:00:00,003 p=12 u=root n=ansible | db1 : ok=4 changed=2 unreachable=0 failed=1\n",
+			expected: map[string]string{
+				"ansible_ok":            "9",
+				"ansible_changed":       "3",
+				"ansible_unreachable":   "1",
+				"ansible_failed":        "1",
+				"ansible_changed_hosts": "web1,db1",
+			},
+		},
+		{
+			name: "unknown counters are ignored",
+			log:  "PLAY RECAP ****\nweb1 : ok=1 changed=1 custom=5\n",
+			expected: map[string]string{
+				"ansible_ok":            "1",
+				"ansible_changed":       "1",
+				"ansible_changed_hosts": "web1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if outputs := parsePlayRecap(tt.log); !reflect.DeepEqual(outputs, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, outputs)
+			}
+		})
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"gopkg.in/yaml.v3"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/gosimple/hashdir"
 	"github.com/mitchellh/mapstructure"
 
 	//"github.com/imdario/mergo"
@@ -212,11 +213,18 @@ type Block struct {
 	Inventory   BlockInventory              `yaml:"inventory,omitempty" mapstructure:"inventory,omitempty" json:"inventory,omitempty"`
 	Kubeconfig  BlockKubeconfig             `yaml:"kubeconfig,omitempty" mapstructure:"kubeconfig,omitempty" json:"kubeconfig,omitempty"`
 	Artifacts   BlockArtifacts              `yaml:"artifacts,omitempty" mapstructure:"artifacts,omitempty" json:"artifacts,omitempty"`
+	Container   ContainerConfig             `yaml:"container,omitempty" mapstructure:"container,omitempty" json:"container,omitempty"`
 	Checksum    string                      `yaml:"checksum,omitempty" mapstructure:"checksum,omitempty" json:"checksum,omitempty"`
 	resolved    bool
 	schema      string
 	workspace   *Workspace
 	blockConfig *yaml.Node `yaml:"-"`
+	// Digest of the image the block has been pulled from
+	digest string
+	// Content of the block directory the checksum has been computed from
+	manifest *BlockManifest
+	// What has been pulled into the block directory; nil if the block hasn't been pulled
+	metadata *BlockMetadata
 }
 
 type SSHHost struct {
@@ -261,7 +269,7 @@ func (b *Block) getHostsFromInventory(tx *PolycrateTransaction) (*viper.Viper, e
 	}
 
 	tx.Log.Infof("Starting container for inventory conversion")
-	err = workspace.RunContainer(tx, containerName, workspace.ContainerPath, cmd)
+	err = workspace.RunContainer(tx.Context, tx, containerName, workspace.ContainerPath, cmd, ContainerConfig{})
 	if err != nil {
 		return nil, err
 	}
@@ -428,7 +436,7 @@ func (b *Block) ConvertInventory(tx *PolycrateTransaction) error {
 		"--output-file",
 		f.Name(),
 	}
-	err = workspace.RunContainer(tx, containerName, workspace.ContainerPath, cmd)
+	err = workspace.RunContainer(tx.Context, tx, containerName, workspace.ContainerPath, cmd, ContainerConfig{})
 	if err != nil {
 		return err
 	}
@@ -543,6 +551,14 @@ func (c *Block) getInventoryPath(tx *PolycrateTransaction) string {
 }
 
 func (b *Block) getKubeconfigPath(tx *PolycrateTransaction) string {
+	if kubeconfig := b.getKubeconfig(tx); kubeconfig != nil {
+		return kubeconfig.Path
+	}
+	return ""
+}
+
+// Returns the kubeconfig of the block or the block it takes its kubeconfig from
+func (b *Block) getKubeconfig(tx *PolycrateTransaction) *BlockKubeconfig {
 	workspace := b.workspace
 
 	if b.Kubeconfig.From != "" {
@@ -554,14 +570,158 @@ func (b *Block) getKubeconfigPath(tx *PolycrateTransaction) string {
 		}
 
 		if kubeconfigSourceBlock != nil {
-			return kubeconfigSourceBlock.Kubeconfig.Path
+			return &kubeconfigSourceBlock.Kubeconfig
 		} else {
 			tx.Log.Errorf("Kubeconfig source '%s' not found", b.Kubeconfig.From)
 		}
 	} else {
-		return b.Kubeconfig.Path
+		return &b.Kubeconfig
 	}
-	return ""
+	return nil
+}
+
+// What has been pulled into the directory of an installed block
+// It's stored next to the block directory so it doesn't change the checksum of the block
+type BlockMetadata struct {
+	// The reference the block has been pulled from (registry/name:version)
+	Reference string `yaml:"reference,omitempty" mapstructure:"reference,omitempty" json:"reference,omitempty"`
+	Digest    string `yaml:"digest,omitempty" mapstructure:"digest,omitempty" json:"digest,omitempty"`
+	// Checksum of the block directory right after it has been pulled
+	Checksum     string `yaml:"checksum,omitempty" mapstructure:"checksum,omitempty" json:"checksum,omitempty"`
+	ChecksumAlgo string `yaml:"checksum_algo,omitempty" mapstructure:"checksum_algo,omitempty" json:"checksum_algo,omitempty"`
+	// Content of the block directory right after it has been pulled
+	Manifest *BlockManifest `yaml:"manifest,omitempty" mapstructure:"manifest,omitempty" json:"manifest,omitempty"`
+	// md5 checksums depend on the location of the block directory
+	Path string `yaml:"path,omitempty" mapstructure:"path,omitempty" json:"path,omitempty"`
+	Date string `yaml:"date,omitempty" mapstructure:"date,omitempty" json:"date,omitempty"`
+}
+
+// Returns the path of the metadata file of the block directory (e.g. blocks/acme/.dep.meta.yml for blocks/acme/dep)
+func getBlockMetadataPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+BlocksMetadataSuffix)
+}
+
+// Loads the metadata of the block directory; blocks that haven't been pulled have none
+func loadBlockMetadata(path string) (*BlockMetadata, error) {
+	data, err := os.ReadFile(getBlockMetadataPath(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	metadata := &BlockMetadata{}
+	if err := yaml.Unmarshal(data, metadata); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata of block at '%s': %s", path, err)
+	}
+	return metadata, nil
+}
+
+func saveBlockMetadata(path string, metadata *BlockMetadata) error {
+	data, err := yaml.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(getBlockMetadataPath(path), data, 0644)
+}
+
+// Splits a block reference into the reference without the digest and the digest (e.g. acme/dep:1.0@sha256:...)
+func splitDigest(reference string) (string, string) {
+	if i := strings.Index(reference, "@"); i >= 0 {
+		return reference[:i], reference[i+1:]
+	}
+	return reference, ""
+}
+
+// Compares the installed block with the metadata recorded when it has been pulled
+// Returns nil if the block can't be verified, i.e. it has no manifest and has been pulled to another location
+// Metadata of blocks pulled with md5 checksums has no manifest; only the checksum of the whole directory is compared then
+func (b *Block) getModifications(tx *PolycrateTransaction, metadata *BlockMetadata) (*BlockManifestDiff, error) {
+	if metadata.Manifest != nil {
+		return b.manifest.Diff(metadata.Manifest), nil
+	}
+
+	if metadata.Checksum == "" {
+		return nil, nil
+	}
+
+	if metadata.Path != b.Workdir.LocalPath {
+		tx.Log.Debugf("Block '%s' has been moved from '%s'. Not verifying its md5 checksum", b.Name, metadata.Path)
+		return nil, nil
+	}
+
+	checksum, err := hashdir.Make(b.Workdir.LocalPath, BlockChecksumAlgorithmMD5)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &BlockManifestDiff{}
+	if checksum != metadata.Checksum {
+		// Without a manifest, the modified files are unknown
+		diff.Modified = []string{"."}
+	}
+	return diff, nil
+}
+
+// Compares the content of a pulled block with the checksum in the labels of its image
+// md5 checksums of images pushed by earlier versions contain the absolute paths of the files
+// on the machine the block has been pushed from, so they can't be verified
+func verifyPushedChecksum(tx *PolycrateTransaction, reference string, manifest *BlockManifest, labels map[string]string, mode string) error {
+	checksum := labels[BlocksChecksumLabel]
+	if mode == WorkspaceVerifyBlocksOff || checksum == "" {
+		return nil
+	}
+
+	algorithm := labels[BlocksChecksumAlgoLabel]
+	if algorithm != BlockChecksumAlgorithmSHA256 {
+		tx.Log.Debugf("Not verifying the %s checksum of block %s. It has been pushed by an earlier version of polycrate", BlockChecksumAlgorithmMD5, reference)
+		return nil
+	}
+
+	if manifest.Checksum() != checksum {
+		err := fmt.Errorf("content of block %s doesn't match the checksum it has been pushed with (expected %s, got %s)", reference, checksum, manifest.Checksum())
+		if mode == WorkspaceVerifyBlocksFail {
+			return err
+		}
+		tx.Log.Warn(err)
+	}
+	return nil
+}
+
+// Compares the installed block with the metadata recorded when it has been pulled
+func (b *Block) verifyMetadata(tx *PolycrateTransaction, metadata *BlockMetadata, mode string) error {
+	if mode == WorkspaceVerifyBlocksOff {
+		return nil
+	}
+
+	diff, err := b.getModifications(tx, metadata)
+	if err != nil {
+		return err
+	}
+
+	if diff != nil && !diff.Empty() {
+		err := fmt.Errorf("block '%s' has been modified since it has been pulled from %s (digest %s; %s). Run `polycrate blocks verify %s` to list the modified files or `polycrate block pull %s@%s` to restore it", b.Name, metadata.Reference, metadata.Digest, diff, b.Name, metadata.Reference, metadata.Digest)
+		if mode == WorkspaceVerifyBlocksFail {
+			return err
+		}
+		tx.Log.Warn(err)
+	}
+	return nil
+}
+
+// Decodes the config of the block into the well-known config options (e.g. namespace or chart)
+func (b *Block) getConfig() (*BlockConfig, error) {
+	config := &BlockConfig{}
+
+	data, err := yaml.Marshal(b.Config)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to decode config of block '%s': %s", b.Name, err)
+	}
+	return config, nil
 }
 
 func (b *Block) GetAction(name string) (*Action, error) {
@@ -767,9 +927,12 @@ func (c *Block) MergeIn(block *Block) error {
 				destinationAction.Block = c.Name
 				destinationAction.block = c
 			} else {
-				sourceAction.Block = c.Name
-				sourceAction.block = c
-				c.Actions = append(c.Actions, sourceAction)
+				// Copy the action, otherwise all blocks derived from the same
+				// block share (and overwrite) the block of the inherited action
+				action := *sourceAction
+				action.Block = c.Name
+				action.block = c
+				c.Actions = append(c.Actions, &action)
 			}
 		}
 	}
@@ -850,6 +1013,11 @@ func (c *Block) MergeIn(block *Block) error {
 		}
 	}
 
+	// Container
+	if err := mergo.Merge(&c.Container, block.Container); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -0,0 +1,51 @@
+/*
+Copyright © 2021 Fabian Peter <fp@ayedo.de>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var blocksGraphFormat string
+
+var blocksGraphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Show the block graph",
+	Long:  `Show which blocks inherit from which other blocks ("from:") as a tree, in DOT format or as JSON.`,
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		_w := cmd.Flags().Lookup("workspace").Value.String()
+
+		tx := polycrate.Transaction().SetCommand(cmd)
+		defer tx.Stop()
+
+		workspace, err := polycrate.LoadWorkspace(tx, _w, true)
+		if err != nil {
+			tx.Log.Fatal(err)
+		}
+
+		err = workspace.PrintBlockGraph(blocksGraphFormat)
+		if err != nil {
+			tx.Log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	blocksGraphCmd.Flags().StringVar(&blocksGraphFormat, "format", "tree", "Format of the graph (tree, dot or json)")
+
+	blocksCmd.AddCommand(blocksGraphCmd)
+}
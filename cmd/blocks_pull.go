@@ -21,7 +21,7 @@ import (
 
 // installCmd represents the install command
 var blocksPullCmd = &cobra.Command{
-	Use:   "pull BLOCK:VERSION",
+	Use:   "pull BLOCK[:VERSION][@DIGEST]",
 	Short: "Pull a block from the registry",
 	Long:  ``,
 	Args:  cobra.ExactArgs(1),
@@ -38,14 +38,15 @@ var blocksPullCmd = &cobra.Command{
 			tx.Log.Fatal(err)
 		}
 
-		fullTag, registryUrl, blockName, blockVersion := mapDockerTag(blockInfo)
+		reference, digest := splitDigest(blockInfo)
+		fullTag, registryUrl, blockName, blockVersion := mapDockerTag(reference)
 
 		// blockName, blockVersion, err := registry.resolveArg(blockInfo)
 		// if err != nil {
 		// 	log.Fatal(err)
 		// }
 
-		_, err = workspace.PullBlock(tx, fullTag, registryUrl, blockName, blockVersion)
+		_, err = workspace.PullBlock(tx, fullTag, registryUrl, blockName, blockVersion, digest)
 		if err != nil {
 			tx.Log.Fatal(err)
 		}
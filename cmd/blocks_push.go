@@ -19,6 +19,9 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// Sign the pushed block with the signing key
+var signBlock bool
+
 // installCmd represents the install command
 var blocksPushCmd = &cobra.Command{
 	Use:   "push BLOCK",
@@ -47,4 +50,7 @@ var blocksPushCmd = &cobra.Command{
 
 func init() {
 	blocksCmd.AddCommand(blocksPushCmd)
+
+	blocksPushCmd.Flags().BoolVar(&signBlock, "sign", false, "Sign the block and push the signature next to it")
+	blocksPushCmd.Flags().StringVar(&signingKeyPath, "key", "", "Private ed25519 key to sign the block with (default: "+BlocksSigningKey+" in the config directory; created if missing)")
 }
@@ -0,0 +1,54 @@
+/*
+Copyright © 2021 Fabian Peter <fp@ayedo.de>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var blocksSignCmd = &cobra.Command{
+	Use:   "sign BLOCK[:VERSION][@DIGEST]",
+	Short: "Sign a block in the registry",
+	Long:  `Sign a block that has been pushed to the registry with a local ed25519 key and push the signature next to it. The key pair is created if it doesn't exist; add its public key to the trust policy of workspaces that pull the block.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		_w := cmd.Flags().Lookup("workspace").Value.String()
+		blockInfo := args[0]
+
+		tx := polycrate.Transaction()
+		tx.SetCommand(cmd)
+		defer tx.Stop()
+
+		workspace, err := polycrate.PreloadWorkspace(tx, _w, true)
+		if err != nil {
+			tx.Log.Fatal(err)
+		}
+
+		reference, digest := splitDigest(blockInfo)
+		_, registryUrl, blockName, blockVersion := mapDockerTag(reference)
+
+		err = workspace.SignBlock(tx, registryUrl, blockName, blockVersion, digest)
+		if err != nil {
+			tx.Log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	blocksCmd.AddCommand(blocksSignCmd)
+
+	blocksSignCmd.Flags().StringVar(&signingKeyPath, "key", "", "Private ed25519 key to sign the block with (default: "+BlocksSigningKey+" in the config directory; created if missing)")
+}
@@ -0,0 +1,52 @@
+/*
+Copyright © 2021 Fabian Peter <fp@ayedo.de>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// Set while blocks are verified, so loading the workspace doesn't fail on modified blocks
+var verifyingBlocks bool
+
+var blocksVerifyCmd = &cobra.Command{
+	Use:   "verify [BLOCK...]",
+	Short: "Verify pulled blocks",
+	Long:  `Compare pulled blocks with the content manifest recorded when they have been pulled and list the files that have been modified, added or removed since. Fails if any block has been modified.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		_w := cmd.Flags().Lookup("workspace").Value.String()
+
+		tx := polycrate.Transaction()
+		tx.SetCommand(cmd)
+		defer tx.Stop()
+
+		verifyingBlocks = true
+
+		workspace, err := polycrate.PreloadWorkspace(tx, _w, true)
+		if err != nil {
+			tx.Log.Fatal(err)
+		}
+
+		err = workspace.VerifyBlocks(tx, args)
+		if err != nil {
+			tx.Log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	blocksCmd.AddCommand(blocksVerifyCmd)
+}
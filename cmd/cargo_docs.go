@@ -246,7 +246,7 @@ var cargoDocsCmd = &cobra.Command{
 
 							log.Debugf("Downloading %s/%s:%s to %s", catalogBlock.Registry, catalogBlock.Name, tag.Version, blockDownloadPath)
 
-							if err := UnwrapOCIImage(ctx, blockDownloadPath, catalogBlock.Registry, catalogBlock.Name, tag.Version); err != nil {
+							if _, err := UnwrapOCIImage(ctx, blockDownloadPath, catalogBlock.Registry, catalogBlock.Name, tag.Version); err != nil {
 								log.Fatal(err)
 							}
 						}
@@ -16,16 +16,37 @@ limitations under the License.
 package cmd
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
-	"github.com/gosimple/hashdir"
 	"github.com/spf13/cobra"
 )
 
+// Algorithms of block checksums
+// md5 checksums have been computed with hashdir and are only read for compatibility
+const (
+	BlockChecksumAlgorithmMD5    string = "md5"
+	BlockChecksumAlgorithmSHA256 string = "sha256"
+)
+
+// Labels of a block image with the checksum of the block directory it has been pushed from
+// Images pushed by earlier versions have md5 checksums or no algorithm label at all
+const (
+	BlocksChecksumLabel     string = "polycrate.blocks.checksum"
+	BlocksChecksumAlgoLabel string = "polycrate.blocks.checksum_algo"
+)
+
 var checksumCmd = &cobra.Command{
 	Use:   "checksum",
-	Short: "Return MD5 Checksum of directory",
-	Long:  `Return MD5 Checksum of directory`,
+	Short: "Return SHA-256 Checksum of directory",
+	Long:  `Return the SHA-256 Checksum of the content manifest of a directory. Use --manifest to print the manifest`,
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		path := args[0]
@@ -35,16 +56,177 @@ var checksumCmd = &cobra.Command{
 		defer tx.Stop()
 
 		// Compute block directory hash
-		checksum, err := hashdir.Make(path, "md5")
+		manifest, err := NewBlockManifest(path)
 		if err != nil {
 			tx.Log.Fatal(err)
 		}
 
-		fmt.Println(checksum)
-
+		if printManifest {
+			for _, file := range manifest.Files {
+				fmt.Println(file)
+			}
+			return
+		}
+		fmt.Println(manifest.Checksum())
 	},
 }
 
+var printManifest bool
+
 func init() {
 	rootCmd.AddCommand(checksumCmd)
+
+	checksumCmd.Flags().BoolVar(&printManifest, "manifest", false, "Print the content manifest instead of the checksum")
+}
+
+// The content of a block directory; every file with its mode and SHA-256 hash
+type BlockManifest struct {
+	Algorithm string              `yaml:"algorithm,omitempty" mapstructure:"algorithm,omitempty" json:"algorithm,omitempty"`
+	Files     []BlockManifestFile `yaml:"files,omitempty" mapstructure:"files,omitempty" json:"files,omitempty"`
+}
+
+type BlockManifestFile struct {
+	// Path relative to the block directory
+	Path string `yaml:"path" mapstructure:"path" json:"path"`
+	Mode string `yaml:"mode" mapstructure:"mode" json:"mode"`
+	Hash string `yaml:"hash" mapstructure:"hash" json:"hash"`
+}
+
+func (f BlockManifestFile) String() string {
+	return fmt.Sprintf("%s %s %s", f.Hash, f.Mode, f.Path)
+}
+
+// Creates the manifest of the directory
+// Paths are relative, so the manifest doesn't change when the directory is moved
+// Symlinks are recorded with the hash of their target path
+func NewBlockManifest(path string) (*BlockManifest, error) {
+	manifest := &BlockManifest{
+		Algorithm: BlockChecksumAlgorithmSHA256,
+		Files:     []BlockManifestFile{},
+	}
+
+	err := filepath.WalkDir(path, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(path, filePath)
+		if err != nil {
+			return err
+		}
+
+		hash := sha256.New()
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(filePath)
+			if err != nil {
+				return err
+			}
+			hash.Write([]byte(target))
+		} else {
+			f, err := os.Open(filePath)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			if _, err := io.Copy(hash, f); err != nil {
+				return err
+			}
+		}
+
+		manifest.Files = append(manifest.Files, BlockManifestFile{
+			Path: filepath.ToSlash(relPath),
+			Mode: fmt.Sprintf("%04o", info.Mode().Perm()),
+			Hash: hex.EncodeToString(hash.Sum(nil)),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// WalkDir walks in lexical order already; sorting keeps the checksum stable regardless
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Path < manifest.Files[j].Path
+	})
+	return manifest, nil
+}
+
+// Returns the SHA-256 hash of the manifest
+func (m *BlockManifest) Checksum() string {
+	hash := sha256.New()
+	for _, file := range m.Files {
+		fmt.Fprintln(hash, file)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Changes of a directory compared to its manifest
+type BlockManifestDiff struct {
+	Added    []string `yaml:"added,omitempty" mapstructure:"added,omitempty" json:"added,omitempty"`
+	Removed  []string `yaml:"removed,omitempty" mapstructure:"removed,omitempty" json:"removed,omitempty"`
+	Modified []string `yaml:"modified,omitempty" mapstructure:"modified,omitempty" json:"modified,omitempty"`
+	// Files with the same content but a different mode
+	ModeChanged []string `yaml:"mode_changed,omitempty" mapstructure:"mode_changed,omitempty" json:"mode_changed,omitempty"`
+}
+
+func (d *BlockManifestDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0 && len(d.ModeChanged) == 0
+}
+
+func (d *BlockManifestDiff) String() string {
+	changes := []string{}
+	for _, c := range []struct {
+		name  string
+		files []string
+	}{
+		{"modified", d.Modified},
+		{"added", d.Added},
+		{"removed", d.Removed},
+		{"mode changed", d.ModeChanged},
+	} {
+		if len(c.files) > 0 {
+			changes = append(changes, fmt.Sprintf("%d %s", len(c.files), c.name))
+		}
+	}
+	return strings.Join(changes, ", ")
+}
+
+// Compares the manifest of the current content (m) with the recorded manifest
+func (m *BlockManifest) Diff(recorded *BlockManifest) *BlockManifestDiff {
+	diff := &BlockManifestDiff{}
+
+	recordedFiles := map[string]BlockManifestFile{}
+	for _, file := range recorded.Files {
+		recordedFiles[file.Path] = file
+	}
+
+	for _, file := range m.Files {
+		recordedFile, ok := recordedFiles[file.Path]
+		if !ok {
+			diff.Added = append(diff.Added, file.Path)
+			continue
+		}
+		delete(recordedFiles, file.Path)
+
+		if recordedFile.Hash != file.Hash {
+			diff.Modified = append(diff.Modified, file.Path)
+		} else if recordedFile.Mode != file.Mode {
+			diff.ModeChanged = append(diff.ModeChanged, file.Path)
+		}
+	}
+
+	for path := range recordedFiles {
+		diff.Removed = append(diff.Removed, path)
+	}
+	sort.Strings(diff.Removed)
+	return diff
 }
@@ -0,0 +1,260 @@
+/*
+Copyright © 2021 Fabian Peter <fp@ayedo.de>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBlockManifestDiff(t *testing.T) {
+	recorded := &BlockManifest{Files: []BlockManifestFile{
+		{Path: "block.poly", Mode: "0644", Hash: "a"},
+		{Path: "files/config.yml", Mode: "0644", Hash: "b"},
+		{Path: "scripts/run.sh", Mode: "0755", Hash: "c"},
+	}}
+
+	tests := []struct {
+		name     string
+		files    []BlockManifestFile
+		expected *BlockManifestDiff
+	}{
+		{
+			name:     "unchanged",
+			files:    recorded.Files,
+			expected: &BlockManifestDiff{},
+		},
+		{
+			name: "modified file",
+			files: []BlockManifestFile{
+				{Path: "block.poly", Mode: "0644", Hash: "x"},
+				{Path: "files/config.yml", Mode: "0644", Hash: "b"},
+				{Path: "scripts/run.sh", Mode: "0755", Hash: "c"},
+			},
+			expected: &BlockManifestDiff{Modified: []string{"block.poly"}},
+		},
+		{
+			name: "modified file with a new mode is only modified",
+			files: []BlockManifestFile{
+				{Path: "block.poly", Mode: "0644", Hash: "a"},
+				{Path: "files/config.yml", Mode: "0600", Hash: "x"},
+				{Path: "scripts/run.sh", Mode: "0755", Hash: "c"},
+			},
+			expected: &BlockManifestDiff{Modified: []string{"files/config.yml"}},
+		},
+		{
+			name: "mode changed",
+			files: []BlockManifestFile{
+				{Path: "block.poly", Mode: "0644", Hash: "a"},
+				{Path: "files/config.yml", Mode: "0644", Hash: "b"},
+				{Path: "scripts/run.sh", Mode: "0644", Hash: "c"},
+			},
+			expected: &BlockManifestDiff{ModeChanged: []string{"scripts/run.sh"}},
+		},
+		{
+			name: "added and removed files",
+			files: []BlockManifestFile{
+				{Path: "block.poly", Mode: "0644", Hash: "a"},
+				{Path: "files/new.yml", Mode: "0644", Hash: "d"},
+			},
+			expected: &BlockManifestDiff{
+				Added:   []string{"files/new.yml"},
+				Removed: []string{"files/config.yml", "scripts/run.sh"},
+			},
+		},
+		{
+			name: "renamed file",
+			files: []BlockManifestFile{
+				{Path: "block.poly", Mode: "0644", Hash: "a"},
+				{Path: "files/config.yaml", Mode: "0644", Hash: "b"},
+				{Path: "scripts/run.sh", Mode: "0755", Hash: "c"},
+			},
+			expected: &BlockManifestDiff{
+				Added:   []string{"files/config.yaml"},
+				Removed: []string{"files/config.yml"},
+			},
+		},
+		{
+			name:     "empty directory",
+			files:    []BlockManifestFile{},
+			expected: &BlockManifestDiff{Removed: []string{"block.poly", "files/config.yml", "scripts/run.sh"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := &BlockManifest{Files: tt.files}
+
+			diff := current.Diff(recorded)
+			if !reflect.DeepEqual(diff, tt.expected) {
+				t.Fatalf("expected %+v, got %+v", tt.expected, diff)
+			}
+			if diff.Empty() != reflect.DeepEqual(tt.expected, &BlockManifestDiff{}) {
+				t.Errorf("unexpected Empty() for %+v", diff)
+			}
+		})
+	}
+}
+
+func TestBlockManifestDiffString(t *testing.T) {
+	diff := &BlockManifestDiff{
+		Added:       []string{"a", "b"},
+		Modified:    []string{"c"},
+		ModeChanged: []string{"d"},
+	}
+	if s := diff.String(); s != "1 modified, 2 added, 1 mode changed" {
+		t.Errorf("unexpected summary: %s", s)
+	}
+}
+
+func TestNewBlockManifest(t *testing.T) {
+	writeFiles := func(t *testing.T, dir string) {
+		for path, mode := range map[string]os.FileMode{
+			"block.poly":       0644,
+			"files/config.yml": 0644,
+			"scripts/run.sh":   0755,
+		} {
+			path = filepath.Join(dir, path)
+			if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(path[len(dir):]), mode); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(path, mode); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.Symlink("scripts/run.sh", filepath.Join(dir, "run")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, second := t.TempDir(), t.TempDir()
+	writeFiles(t, first)
+	writeFiles(t, second)
+
+	manifest, err := NewBlockManifest(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Algorithm != BlockChecksumAlgorithmSHA256 || len(manifest.Files) != 4 {
+		t.Fatalf("unexpected manifest: %+v", manifest)
+	}
+
+	// The checksum doesn't depend on where the directory is
+	moved, err := NewBlockManifest(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Checksum() != moved.Checksum() {
+		t.Errorf("expected the same checksum for the same content")
+	}
+
+	if err := os.WriteFile(filepath.Join(second, "block.poly"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(second, "scripts/run.sh"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(second, "files/config.yml")); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := NewBlockManifest(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Checksum() == changed.Checksum() {
+		t.Errorf("expected the checksum to change")
+	}
+
+	expected := &BlockManifestDiff{
+		Removed:     []string{"files/config.yml"},
+		Modified:    []string{"block.poly"},
+		ModeChanged: []string{"scripts/run.sh"},
+	}
+	if diff := changed.Diff(manifest); !reflect.DeepEqual(diff, expected) {
+		t.Errorf("expected %+v, got %+v", expected, diff)
+	}
+}
+
+func TestVerifyPushedChecksum(t *testing.T) {
+	tx := newTestTransaction()
+	defer tx.CancelFunc()
+
+	manifest := &BlockManifest{Algorithm: BlockChecksumAlgorithmSHA256, Files: []BlockManifestFile{
+		{Path: "block.poly", Mode: "0644", Hash: "a"},
+	}}
+	checksum := manifest.Checksum()
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		mode   string
+		err    bool
+	}{
+		{
+			name:   "matching checksum",
+			labels: map[string]string{BlocksChecksumLabel: checksum, BlocksChecksumAlgoLabel: BlockChecksumAlgorithmSHA256},
+			mode:   WorkspaceVerifyBlocksFail,
+		},
+		{
+			name:   "different checksum",
+			labels: map[string]string{BlocksChecksumLabel: "other", BlocksChecksumAlgoLabel: BlockChecksumAlgorithmSHA256},
+			mode:   WorkspaceVerifyBlocksFail,
+			err:    true,
+		},
+		{
+			name:   "different checksum only warns",
+			labels: map[string]string{BlocksChecksumLabel: "other", BlocksChecksumAlgoLabel: BlockChecksumAlgorithmSHA256},
+			mode:   WorkspaceVerifyBlocksWarn,
+		},
+		{
+			name:   "verification disabled",
+			labels: map[string]string{BlocksChecksumLabel: "other", BlocksChecksumAlgoLabel: BlockChecksumAlgorithmSHA256},
+			mode:   WorkspaceVerifyBlocksOff,
+		},
+		{
+			name:   "md5 checksum of an earlier version",
+			labels: map[string]string{BlocksChecksumLabel: "d41d8cd98f00b204e9800998ecf8427e", BlocksChecksumAlgoLabel: BlockChecksumAlgorithmMD5},
+			mode:   WorkspaceVerifyBlocksFail,
+		},
+		{
+			name:   "checksum without algorithm label",
+			labels: map[string]string{BlocksChecksumLabel: "d41d8cd98f00b204e9800998ecf8427e"},
+			mode:   WorkspaceVerifyBlocksFail,
+		},
+		{
+			name:   "image without checksum",
+			labels: map[string]string{},
+			mode:   WorkspaceVerifyBlocksFail,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyPushedChecksum(tx, "registry/blocks/app:1.0.0", manifest, tt.labels, tt.mode)
+			if tt.err && err == nil {
+				t.Errorf("expected an error")
+			}
+			if !tt.err && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
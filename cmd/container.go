@@ -11,6 +11,7 @@ import (
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/jsonmessage"
 )
@@ -50,6 +51,22 @@ func buildContainerImage(ctx context.Context, path string, dockerfilePath string
 	return tags[0], nil
 }
 
+// Returns true if the image exists locally
+func containerImageExists(ctx context.Context, name string) (bool, error) {
+	cli, err := getDockerCLI()
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := cli.ImageInspect(ctx, name); err != nil {
+		if errdefs.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
 func PullImageGo(ctx context.Context, _image string) error {
 	os.Setenv("DOCKER_API_VERSION", "1.47")
 	cli, err := client.NewClientWithOpts(client.FromEnv)
@@ -204,7 +221,27 @@ func PruneContainer(tx *PolycrateTransaction, filters []string) (int, string, er
 	return exitCode, output, err
 }
 
-func RunContainer(tx *PolycrateTransaction, image string, command []string, env []string, mounts []string, workdir string, ports []string, labels []string, name string) (int, string, error) {
+// Container settings of an action or block that override the workspace defaults
+type ContainerConfig struct {
+	// Image reference (e.g. alpine:3.20); takes precedence over dockerfile
+	Image string `yaml:"image,omitempty" mapstructure:"image,omitempty" json:"image,omitempty"`
+	// Dockerfile relative to the block; the image is built with the block as context
+	Dockerfile string `yaml:"dockerfile,omitempty" mapstructure:"dockerfile,omitempty" json:"dockerfile,omitempty"`
+	// Number of CPUs (e.g. 1.5)
+	Cpus string `yaml:"cpus,omitempty" mapstructure:"cpus,omitempty" json:"cpus,omitempty" validate:"omitempty,numeric"`
+	// Memory limit (e.g. 512m or 2g)
+	Memory string `yaml:"memory,omitempty" mapstructure:"memory,omitempty" json:"memory,omitempty"`
+	// User (and group) to run as (e.g. 1000:1000)
+	User string `yaml:"user,omitempty" mapstructure:"user,omitempty" json:"user,omitempty"`
+	// Additional mounts in the format '/host:/container'
+	Mounts []string `yaml:"mounts,omitempty" mapstructure:"mounts,omitempty" json:"mounts,omitempty"`
+	// Network mode; defaults to host
+	Network string `yaml:"network,omitempty" mapstructure:"network,omitempty" json:"network,omitempty"`
+	// Build context of the dockerfile
+	context string
+}
+
+func RunContainer(ctx context.Context, tx *PolycrateTransaction, image string, command []string, env []string, mounts []string, workdir string, ports []string, labels []string, name string, config ContainerConfig) (int, string, error) {
 	// Prepare container command
 	var runCmd []string
 
@@ -247,12 +284,28 @@ func RunContainer(tx *PolycrateTransaction, image string, command []string, env
 		runCmd = append(runCmd, []string{"-t"}...)
 	}
 
+	// Resources
+	if config.Cpus != "" {
+		runCmd = append(runCmd, []string{"--cpus", config.Cpus}...)
+	}
+	if config.Memory != "" {
+		runCmd = append(runCmd, []string{"--memory", config.Memory}...)
+	}
+
+	// User
+	if config.User != "" {
+		runCmd = append(runCmd, []string{"--user", config.User}...)
+	}
+
 	// Entrypoint
 	entrypointCmd := []string{"--entrypoint", "/bin/bash"}
 	runCmd = append(runCmd, entrypointCmd...)
 
 	// Network
 	network := []string{"--network", "host"}
+	if config.Network != "" {
+		network = []string{"--network", config.Network}
+	}
 	runCmd = append(runCmd, network...)
 
 	// Image
@@ -262,7 +315,7 @@ func RunContainer(tx *PolycrateTransaction, image string, command []string, env
 	runCmd = append(runCmd, command...)
 
 	// Run container
-	exitCode, output, err := RunCommand(tx.Context, env, "docker", runCmd...)
+	exitCode, output, err := RunCommand(ctx, env, "docker", runCmd...)
 
 	return exitCode, output, err
 }
@@ -346,7 +399,8 @@ func RemoveContainer(tx *PolycrateTransaction, container string) error {
 	runCmd = append(runCmd, []string{"rm", "--force", container}...)
 
 	// Remove container
-	_, _, err := RunCommandWithOutput(tx.Context, nil, "docker", runCmd...)
+	// This runs without the context of the transaction so containers of cancelled transactions are removed, too
+	_, _, err := RunCommandWithOutput(context.Background(), nil, "docker", runCmd...)
 
 	return err
 }
@@ -0,0 +1,171 @@
+/*
+Copyright © 2021 Fabian Peter <fp@ayedo.de>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Helm actions
+const (
+	ActionHelmInstall   string = "install"
+	ActionHelmUninstall string = "uninstall"
+)
+
+// Returns the script that installs/upgrades or uninstalls the chart of the block
+// The release is named after the block. Its status is written to $POLYCRATE_OUTPUT
+func (a *Action) getHelmScript() ([]string, error) {
+	block := a.block
+
+	config, err := block.getConfig()
+	if err != nil {
+		return nil, err
+	}
+	chart := config.Chart
+
+	if chart.Name == nil || *chart.Name == "" {
+		return nil, fmt.Errorf("block '%s' has no chart configured (config.chart.name)", block.Name)
+	}
+
+	release := shellQuote(block.Name)
+	namespaceArgs := ""
+	if config.Namespace != "" {
+		namespaceArgs = " --namespace " + shellQuote(config.Namespace)
+	}
+
+	script := []string{
+		"#!/bin/bash",
+		"set -euo pipefail",
+		"trap 'exit 1' SIGINT",
+		"trap 'exit 1' SIGTERM",
+	}
+
+	if a.Helm == ActionHelmUninstall {
+		script = append(script,
+			fmt.Sprintf("if helm status %s%s > /dev/null 2>&1; then", release, namespaceArgs),
+			fmt.Sprintf("  helm uninstall %s%s --wait", release, namespaceArgs),
+			"fi",
+			fmt.Sprintf("echo %s >> \"$POLYCRATE_OUTPUT\"", shellQuote("release="+block.Name)),
+			"echo 'status=uninstalled' >> \"$POLYCRATE_OUTPUT\"",
+		)
+		return script, nil
+	}
+
+	if chart.Repo.URL == nil || *chart.Repo.URL == "" {
+		return nil, fmt.Errorf("block '%s' has no chart repository configured (config.chart.repo.url)", block.Name)
+	}
+	repoURL := strings.TrimSuffix(*chart.Repo.URL, "/")
+	auth := chart.Auth.Enabled != nil && *chart.Auth.Enabled
+
+	var chartRef string
+	if chart.OCI != nil && *chart.OCI {
+		repoURL = strings.TrimPrefix(repoURL, "oci://")
+		chartRef = "oci://" + repoURL + "/" + *chart.Name
+
+		if auth {
+			registry := strings.Split(repoURL, "/")[0]
+			if chart.Auth.Registry != nil && *chart.Auth.Registry != "" {
+				registry = *chart.Auth.Registry
+			}
+			script = append(script, fmt.Sprintf("echo \"$POLYCRATE_HELM_PASSWORD\" | helm registry login %s --username \"$POLYCRATE_HELM_USERNAME\" --password-stdin", shellQuote(registry)))
+		}
+	} else {
+		if chart.Repo.Name == nil || *chart.Repo.Name == "" {
+			return nil, fmt.Errorf("block '%s' has no chart repository name configured (config.chart.repo.name)", block.Name)
+		}
+		chartRef = *chart.Repo.Name + "/" + *chart.Name
+
+		repoAdd := fmt.Sprintf("helm repo add %s %s --force-update", shellQuote(*chart.Repo.Name), shellQuote(repoURL))
+		if auth {
+			repoAdd += " --username \"$POLYCRATE_HELM_USERNAME\" --password \"$POLYCRATE_HELM_PASSWORD\""
+		}
+		script = append(script, repoAdd)
+	}
+
+	upgrade := fmt.Sprintf("helm upgrade --install %s %s%s --values \"$POLYCRATE_HELM_VALUES\" --wait", release, shellQuote(chartRef), namespaceArgs)
+	if chart.Version != nil && *chart.Version != "" {
+		upgrade += " --version " + shellQuote(*chart.Version)
+	}
+	if config.CreateNamespace {
+		upgrade += " --create-namespace"
+	}
+
+	script = append(script,
+		upgrade,
+		fmt.Sprintf("helm status %s%s --output json | jq -r '\"release=\\(.name)\", \"namespace=\\(.namespace)\", \"status=\\(.info.status)\", \"revision=\\(.version)\", \"chart=\\(.chart.metadata.name)-\\(.chart.metadata.version)\", \"app_version=\\(.chart.metadata.appVersion)\"' >> \"$POLYCRATE_OUTPUT\"", release, namespaceArgs),
+	)
+	return script, nil
+}
+
+// Saves the values of the chart (the `app` config of the block) and the helm script of the action
+func (a *Action) saveHelmScript(tx *PolycrateTransaction) error {
+	block := a.block
+	workspace := block.workspace
+
+	config, err := block.getConfig()
+	if err != nil {
+		return err
+	}
+
+	values := []byte{}
+	if config.App != nil {
+		if values, err = yaml.Marshal(config.App); err != nil {
+			return err
+		}
+	}
+
+	valuesFilename := strings.Join([]string{slugify([]string{tx.TXID.String(), "helm", "values"}), "yml"}, ".")
+	f, err := polycrate.getTempFile(tx.Context, valuesFilename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(values); err != nil {
+		return err
+	}
+
+	workspace.registerMount(f.Name(), f.Name())
+	workspace.registerEnvVar("POLYCRATE_HELM_VALUES", f.Name())
+
+	// Credentials are passed as env vars so they don't end up in the script
+	// Both are always set since the script references them with `set -u`
+	if chart := config.Chart; chart.Auth.Enabled != nil && *chart.Auth.Enabled {
+		username, password := "", ""
+		if chart.Auth.Username != nil {
+			username = *chart.Auth.Username
+		}
+		if chart.Auth.Password != nil {
+			password = *chart.Auth.Password
+		}
+		workspace.registerEnvVar("POLYCRATE_HELM_USERNAME", username)
+		workspace.registerEnvVar("POLYCRATE_HELM_PASSWORD", password)
+	}
+
+	script, err := a.getHelmScript()
+	if err != nil {
+		return err
+	}
+	return a.saveScript(tx, script)
+}
+
+// Quotes the string for use as a single argument in bash
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
@@ -0,0 +1,61 @@
+/*
+Copyright © 2021 Fabian Peter <fp@ayedo.de>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"", "''"},
+		{"web", "'web'"},
+		{"web servers", "'web servers'"},
+		{"it's", `'it'"'"'s'`},
+		{"''", `''"'"''"'"''`},
+		{"$HOME `id` $(id)", "'$HOME `id` $(id)'"},
+		{`a\b"c`, `'a\b"c'`},
+		{"a;b|c&d", "'a;b|c&d'"},
+		{"line\nbreak", "'line\nbreak'"},
+	}
+
+	bash, lookErr := exec.LookPath("bash")
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			quoted := shellQuote(tt.value)
+			if quoted != tt.expected {
+				t.Fatalf("expected %s, got %s", tt.expected, quoted)
+			}
+
+			// The shell must see the original value as a single argument
+			if lookErr != nil {
+				t.Skip("bash not found")
+			}
+			out, err := exec.Command(bash, "-c", "printf '%s|' "+quoted).Output()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tt.value+"|" {
+				t.Errorf("expected the shell to read %q, got %q", tt.value+"|", string(out))
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright © 2021 Fabian Peter <fp@ayedo.de>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/Masterminds/semver/v3"
+	"gopkg.in/yaml.v2"
+)
+
+// The versions and digests the dependencies of the workspace have been resolved to
+type WorkspaceLockfile struct {
+	Blocks []WorkspaceLockfileBlock `yaml:"blocks" mapstructure:"blocks" json:"blocks"`
+}
+
+type WorkspaceLockfileBlock struct {
+	// Repository of the block (registry/name)
+	Name string `yaml:"name" mapstructure:"name" json:"name"`
+	// Version or semver range as given in the dependencies of the workspace
+	Constraint string `yaml:"constraint" mapstructure:"constraint" json:"constraint"`
+	Version    string `yaml:"version" mapstructure:"version" json:"version"`
+	Digest     string `yaml:"digest" mapstructure:"digest" json:"digest"`
+}
+
+func (l *WorkspaceLockfile) getBlock(name string) *WorkspaceLockfileBlock {
+	for i := range l.Blocks {
+		if l.Blocks[i].Name == name {
+			block := l.Blocks[i]
+			return &block
+		}
+	}
+	return nil
+}
+
+// Adds the block to the lockfile or replaces its entry
+func (l *WorkspaceLockfile) setBlock(block WorkspaceLockfileBlock) {
+	for i := range l.Blocks {
+		if l.Blocks[i].Name == block.Name {
+			l.Blocks[i] = block
+			return
+		}
+	}
+	l.Blocks = append(l.Blocks, block)
+}
+
+func (w *Workspace) getLockfilePath() string {
+	return filepath.Join(w.LocalPath, WorkspaceLockfileName)
+}
+
+// Loads the lockfile of the workspace; a missing lockfile is empty
+func (w *Workspace) LoadLockfile() (*WorkspaceLockfile, error) {
+	lockfile := &WorkspaceLockfile{}
+
+	data, err := os.ReadFile(w.getLockfilePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return lockfile, nil
+		}
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(data, lockfile); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %s", WorkspaceLockfileName, err)
+	}
+	return lockfile, nil
+}
+
+func (w *Workspace) SaveLockfile(tx *PolycrateTransaction, lockfile *WorkspaceLockfile) error {
+	sort.Slice(lockfile.Blocks, func(i, j int) bool {
+		return lockfile.Blocks[i].Name < lockfile.Blocks[j].Name
+	})
+
+	data, err := yaml.Marshal(lockfile)
+	if err != nil {
+		return err
+	}
+
+	tx.Log.Debugf("Saving lockfile to %s", w.getLockfilePath())
+	return os.WriteFile(w.getLockfilePath(), data, 0644)
+}
+
+// Returns the tag of the block that satisfies the given version
+// Exact versions and tags that aren't a semver range (e.g. latest) are returned as-is,
+// ranges (e.g. ~1.2 or >=0.3 <0.4) are resolved to the highest matching tag in the registry
+func resolveBlockVersion(repository string, version string) (string, error) {
+	if _, err := semver.StrictNewVersion(version); err == nil {
+		return version, nil
+	}
+
+	constraint, err := semver.NewConstraint(version)
+	if err != nil {
+		return version, nil
+	}
+
+	tags, err := ListOCITags(repository)
+	if err != nil {
+		return "", fmt.Errorf("failed to list tags of block '%s': %s", repository, err)
+	}
+
+	var best *semver.Version
+	bestTag := ""
+	for _, tag := range tags {
+		v, err := semver.NewVersion(tag)
+		if err != nil {
+			continue
+		}
+		if constraint.Check(v) && (best == nil || v.GreaterThan(best)) {
+			best = v
+			bestTag = tag
+		}
+	}
+
+	if best == nil {
+		return "", fmt.Errorf("no version of block '%s' matches '%s'", repository, version)
+	}
+	return bestTag, nil
+}
@@ -0,0 +1,182 @@
+/*
+Copyright © 2021 Fabian Peter <fp@ayedo.de>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+// Starts a registry for the test and returns its host (127.0.0.1:port)
+func newTestRegistry(t *testing.T) string {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+// Pushes a random image with the given tags and returns its digest
+func pushTestImage(t *testing.T, repository string, tags ...string) string {
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range tags {
+		if err := crane.Push(img, repository+":"+tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return digest.String()
+}
+
+func TestResolveBlockVersion(t *testing.T) {
+	repository := newTestRegistry(t) + "/blocks/app"
+	pushTestImage(t, repository, "0.1.0", "0.2.0", "0.2.3", "1.0.0", "1.1.0", "1.2.0-rc.1", "latest", "0.2.10")
+
+	tests := []struct {
+		name     string
+		version  string
+		expected string
+		err      string
+	}{
+		{name: "exact version", version: "0.2.0", expected: "0.2.0"},
+		{name: "exact version that isn't in the registry", version: "9.9.9", expected: "9.9.9"},
+		{name: "tag", version: "latest", expected: "latest"},
+		{name: "tilde range", version: "~0.2", expected: "0.2.10"},
+		{name: "caret range", version: "^1.0.0", expected: "1.1.0"},
+		{name: "comparison range", version: ">=0.1.0 <0.2.0", expected: "0.1.0"},
+		{name: "wildcard", version: "1.x", expected: "1.1.0"},
+		{name: "prerelease range", version: ">=1.2.0-rc.0", expected: "1.2.0-rc.1"},
+		{name: "no matching version", version: "^2.0.0", err: "no version of block '" + repository + "' matches '^2.0.0'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, err := resolveBlockVersion(repository, tt.version)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v (%s)", tt.err, err, version)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if version != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, version)
+			}
+		})
+	}
+}
+
+func TestResolveBlockVersionUnknownRepository(t *testing.T) {
+	repository := newTestRegistry(t) + "/blocks/missing"
+
+	if _, err := resolveBlockVersion(repository, "~1.0"); err == nil || !strings.HasPrefix(err.Error(), "failed to list tags of block") {
+		t.Errorf("expected an error listing tags, got %v", err)
+	}
+	// Exact versions don't need the registry
+	if version, err := resolveBlockVersion(repository, "1.0.0"); err != nil || version != "1.0.0" {
+		t.Errorf("expected 1.0.0, got %s (%v)", version, err)
+	}
+}
+
+// Pushes a block image with the given block config, as `polycrate block push` does without the base image
+func pushTestBlock(t *testing.T, reference string, config string) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "block.poly"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	layer, err := layerFromDir(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crane.Push(img, reference); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateBlocksKeepsOtherPins(t *testing.T) {
+	defer func(dir string) { polycrateRuntimeDir = dir }(polycrateRuntimeDir)
+	polycrateRuntimeDir = t.TempDir()
+
+	tx := newTestTransaction()
+	defer tx.CancelFunc()
+	if err := os.MkdirAll(filepath.Join(polycrateRuntimeDir, tx.TXID.String()), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	host := newTestRegistry(t)
+	repository := host + "/blocks/app"
+	pushTestBlock(t, repository+":1.0.0", "name: app\nversion: 1.0.0\n")
+	pushTestBlock(t, repository+":1.1.0", "name: app\nversion: 1.1.0\n")
+
+	w := &Workspace{LocalPath: t.TempDir()}
+	w.Config.BlocksRoot = "blocks"
+	w.Config.BlocksConfig = "block.poly"
+	w.Config.VerifyBlocks = WorkspaceVerifyBlocksOff
+
+	// Pins of other dependencies and of pulled parents
+	others := []WorkspaceLockfileBlock{
+		{Name: host + "/blocks/base", Constraint: "~1.0", Version: "1.0.2", Digest: "sha256:aaa"},
+		{Name: host + "/blocks/db", Constraint: "2.0.0", Version: "2.0.0", Digest: "sha256:bbb"},
+	}
+	lockfile := &WorkspaceLockfile{Blocks: append([]WorkspaceLockfileBlock{}, others...)}
+	if err := w.SaveLockfile(tx, lockfile); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.UpdateBlocks(tx, []string{repository + ":^1.0"}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := w.LoadLockfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated.Blocks) != 3 {
+		t.Fatalf("expected 3 locked blocks, got %+v", updated.Blocks)
+	}
+	for _, other := range others {
+		if entry := updated.getBlock(other.Name); entry == nil || !reflect.DeepEqual(*entry, other) {
+			t.Errorf("expected %+v to stay locked, got %+v", other, entry)
+		}
+	}
+	entry := updated.getBlock(repository)
+	if entry == nil || entry.Constraint != "^1.0" || entry.Version != "1.1.0" || entry.Digest == "" {
+		t.Errorf("expected the updated block to be locked to 1.1.0, got %+v", entry)
+	}
+}
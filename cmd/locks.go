@@ -0,0 +1,352 @@
+/*
+Copyright © 2021 Fabian Peter <fp@ayedo.de>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	osuser "os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Scopes of the lock that is held while an action runs
+const (
+	WorkspaceLockScopeBlock     string = "block"
+	WorkspaceLockScopeWorkspace string = "workspace"
+)
+
+// Interval in which a held lock is checked again with --wait
+const WorkspaceLockPollInterval = 1 * time.Second
+
+var locksCmd = &cobra.Command{
+	Use:   "locks",
+	Short: "Control the locks of running actions",
+	Long:  ``,
+	Aliases: []string{
+		"lock",
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		_w := cmd.Flags().Lookup("workspace").Value.String()
+
+		tx := polycrate.Transaction().SetCommand(cmd)
+		defer tx.Stop()
+
+		workspace, err := polycrate.LoadWorkspace(tx, _w, true)
+		if err != nil {
+			tx.Log.Fatal(err)
+		}
+
+		err = workspace.ListLocks()
+		if err != nil {
+			tx.Log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(locksCmd)
+}
+
+// A lock that is held while an action of a block (or the workspace) runs
+type WorkspaceLock struct {
+	Name        string `yaml:"name,omitempty" mapstructure:"name,omitempty" json:"name,omitempty"`
+	Scope       string `yaml:"scope,omitempty" mapstructure:"scope,omitempty" json:"scope,omitempty"`
+	Transaction string `yaml:"transaction,omitempty" mapstructure:"transaction,omitempty" json:"transaction,omitempty"`
+	Action      string `yaml:"action,omitempty" mapstructure:"action,omitempty" json:"action,omitempty"`
+	User        string `yaml:"user,omitempty" mapstructure:"user,omitempty" json:"user,omitempty"`
+	Host        string `yaml:"host,omitempty" mapstructure:"host,omitempty" json:"host,omitempty"`
+	Pid         int    `yaml:"pid,omitempty" mapstructure:"pid,omitempty" json:"pid,omitempty"`
+	Date        string `yaml:"date,omitempty" mapstructure:"date,omitempty" json:"date,omitempty"`
+}
+
+// A lock is stale if the process holding it doesn't exist anymore
+// This can only be decided for locks held on the same host
+func (l *WorkspaceLock) isStale() bool {
+	hostname, _ := os.Hostname()
+	if l.Host != hostname || l.Pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(l.Pid, 0)
+	return errors.Is(err, syscall.ESRCH)
+}
+
+func (l *WorkspaceLock) String() string {
+	return fmt.Sprintf("transaction %s (%s, %s@%s, since %s)", l.Transaction, l.Action, l.User, l.Host, l.Date)
+}
+
+// Locks are kept in the runtime directory instead of the workspace, so they are never synced
+// Every checkout of a workspace has its own locks, identified by the path of the workspace
+func (w *Workspace) getLocksPath() string {
+	path, err := filepath.Abs(w.LocalPath)
+	if err != nil {
+		path = w.LocalPath
+	}
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Join(polycrateRuntimeDir, WorkspaceLocksDir, strings.Join([]string{w.Name, hex.EncodeToString(sum[:6])}, "-"))
+}
+
+func (w *Workspace) getLockPath(name string) string {
+	return filepath.Join(w.getLocksPath(), strings.Join([]string{name, "lock"}, "."))
+}
+
+// Returns the name and scope of the lock an action of the block needs
+func (w *Workspace) getLockName(block *Block) (string, string) {
+	if w.Config.Lock == WorkspaceLockScopeWorkspace {
+		return w.Name, WorkspaceLockScopeWorkspace
+	}
+	return block.Name, WorkspaceLockScopeBlock
+}
+
+func (w *Workspace) readLock(name string) (*WorkspaceLock, error) {
+	data, err := os.ReadFile(w.getLockPath(name))
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &WorkspaceLock{}
+	if err := yaml.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("failed to decode lock '%s': %s", name, err)
+	}
+	return lock, nil
+}
+
+// Creates the lock file; fails with os.ErrExist if the lock is held
+// The lock is written to a temporary file that is linked into place,
+// so other processes never read a lock that is only partially written
+func (w *Workspace) writeLock(lock *WorkspaceLock) error {
+	if err := os.MkdirAll(w.getLocksPath(), os.ModePerm); err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(lock)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(w.getLocksPath(), "*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Link(f.Name(), w.getLockPath(lock.Name))
+}
+
+// Removes the lock if it's still held by the given stale holder
+// Processes breaking the lock are serialized and read the lock again before removing it,
+// so a lock that has been acquired by another process in the meantime is kept
+func (w *Workspace) breakStaleLock(holder *WorkspaceLock) error {
+	guard, err := os.OpenFile(strings.Join([]string{w.getLockPath(holder.Name), "break"}, "."), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer guard.Close()
+
+	if err := syscall.Flock(int(guard.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(guard.Fd()), syscall.LOCK_UN)
+
+	current, err := w.readLock(holder.Name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if current.Transaction != holder.Transaction {
+		return nil
+	}
+
+	if err := os.Remove(w.getLockPath(holder.Name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Acquires the lock for running an action of the block
+// If the lock is held by another process, this fails unless --wait is set
+// Locks held by this process (e.g. by parallel workflow steps) are always waited for
+func (w *Workspace) AcquireLock(tx *PolycrateTransaction, action *Action) (*WorkspaceLock, error) {
+	name, scope := w.getLockName(action.block)
+	hostname, _ := os.Hostname()
+
+	user := tx.UserEmail
+	if user == "" {
+		user = tx.UserName
+	}
+	if user == "" {
+		if current, err := osuser.Current(); err == nil {
+			user = current.Username
+		}
+	}
+
+	lock := &WorkspaceLock{
+		Name:        name,
+		Scope:       scope,
+		Transaction: tx.TXID.String(),
+		Action:      action.getAddress(),
+		User:        user,
+		Host:        hostname,
+		Pid:         os.Getpid(),
+		Date:        time.Now().Format(time.RFC3339),
+	}
+
+	waiting := false
+	for {
+		err := w.writeLock(lock)
+		if err == nil {
+			tx.Log.Debugf("Acquired %s lock '%s'", scope, name)
+			return lock, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		holder, err := w.readLock(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// Released in the meantime
+				continue
+			}
+			return nil, err
+		}
+
+		if holder.isStale() {
+			tx.Log.Warnf("Breaking stale lock '%s' of %s", name, holder)
+			if err := w.breakStaleLock(holder); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if !waitForLock && holder.Pid != os.Getpid() {
+			return nil, fmt.Errorf("%s '%s' is locked by %s. Use --wait to wait for it or 'polycrate locks break %s' to break it", scope, name, holder, name)
+		}
+
+		if !waiting {
+			tx.Log.Warnf("Waiting for lock '%s' held by %s", name, holder)
+			waiting = true
+		}
+
+		select {
+		case <-tx.Context.Done():
+			return nil, tx.Context.Err()
+		case <-time.After(WorkspaceLockPollInterval):
+		}
+	}
+}
+
+// Releases the lock if it's still held by the transaction
+func (w *Workspace) ReleaseLock(tx *PolycrateTransaction, lock *WorkspaceLock) error {
+	holder, err := w.readLock(lock.Name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			tx.Log.Warnf("Lock '%s' has been broken while the action was running", lock.Name)
+			return nil
+		}
+		return err
+	}
+
+	if holder.Transaction != lock.Transaction {
+		tx.Log.Warnf("Lock '%s' has been taken over by %s", lock.Name, holder)
+		return nil
+	}
+
+	tx.Log.Debugf("Releasing %s lock '%s'", lock.Scope, lock.Name)
+	return os.Remove(w.getLockPath(lock.Name))
+}
+
+// Returns all locks of the workspace sorted by name
+func (w *Workspace) GetLocks() ([]*WorkspaceLock, error) {
+	files, err := filepath.Glob(filepath.Join(w.getLocksPath(), "*.lock"))
+	if err != nil {
+		return nil, err
+	}
+
+	locks := []*WorkspaceLock{}
+	for _, file := range files {
+		lock, err := w.readLock(strings.TrimSuffix(filepath.Base(file), ".lock"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		locks = append(locks, lock)
+	}
+
+	sort.Slice(locks, func(i, j int) bool {
+		return locks[i].Name < locks[j].Name
+	})
+	return locks, nil
+}
+
+func (w *Workspace) ListLocks() error {
+	locks, err := w.GetLocks()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSCOPE\tACTION\tTRANSACTION\tUSER\tHOST\tPID\tSINCE\tSTALE")
+	for _, lock := range locks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%t\n", lock.Name, lock.Scope, lock.Action, lock.Transaction, lock.User, lock.Host, lock.Pid, lock.Date, lock.isStale())
+	}
+	return tw.Flush()
+}
+
+// Removes the lock regardless of its holder
+func (w *Workspace) BreakLock(tx *PolycrateTransaction, name string) error {
+	lock, err := w.readLock(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("lock not found: %s", name)
+		}
+		return err
+	}
+
+	prompt := Prompt{
+		Message: fmt.Sprintf("Break lock '%s' held by %s?", name, lock),
+	}
+	if !prompt.Validate() {
+		return fmt.Errorf("not breaking lock. user confirmation declined")
+	}
+
+	if err := os.Remove(w.getLockPath(name)); err != nil {
+		return err
+	}
+	tx.Log.Infof("Broke lock '%s' of %s", name, lock)
+	return nil
+}
@@ -0,0 +1,259 @@
+/*
+Copyright © 2021 Fabian Peter <fp@ayedo.de>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns the pid of a process that has exited
+func exitedPid(t *testing.T) int {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("can't start a process: %s", err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestIsStale(t *testing.T) {
+	hostname, _ := os.Hostname()
+
+	tests := []struct {
+		name     string
+		lock     WorkspaceLock
+		expected bool
+	}{
+		{name: "held by this process", lock: WorkspaceLock{Host: hostname, Pid: os.Getpid()}},
+		{name: "held by a running process", lock: WorkspaceLock{Host: hostname, Pid: os.Getppid()}},
+		{name: "held by an exited process", lock: WorkspaceLock{Host: hostname, Pid: exitedPid(t)}, expected: true},
+		{name: "held on another host", lock: WorkspaceLock{Host: hostname + "-other", Pid: exitedPid(t)}},
+		{name: "without pid", lock: WorkspaceLock{Host: hostname}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if stale := tt.lock.isStale(); stale != tt.expected {
+				t.Errorf("expected stale=%t, got %t", tt.expected, stale)
+			}
+		})
+	}
+}
+
+func TestAcquireLock(t *testing.T) {
+	hostname, _ := os.Hostname()
+
+	tests := []struct {
+		name   string
+		scope  string
+		holder *WorkspaceLock
+		wait   bool
+		err    string
+		// The lock name the action needs
+		lock string
+	}{
+		{name: "free lock", lock: "app"},
+		{name: "workspace scope", scope: WorkspaceLockScopeWorkspace, lock: "ws"},
+		{
+			name:   "held by another process",
+			holder: &WorkspaceLock{Name: "app", Transaction: "other", Host: hostname, Pid: os.Getppid()},
+			err:    "block 'app' is locked by transaction other",
+			lock:   "app",
+		},
+		{
+			name:   "held on another host",
+			holder: &WorkspaceLock{Name: "app", Transaction: "other", Host: hostname + "-other", Pid: 1},
+			err:    "block 'app' is locked by transaction other",
+			lock:   "app",
+		},
+		{
+			name:   "held by a lock of another block",
+			holder: &WorkspaceLock{Name: "db", Transaction: "other", Host: hostname, Pid: os.Getppid()},
+			lock:   "app",
+		},
+		{
+			name:   "stale lock is broken",
+			holder: &WorkspaceLock{Name: "app", Transaction: "other", Host: hostname, Pid: exitedPid(t)},
+			lock:   "app",
+		},
+		{
+			name:   "waiting for another process is cancelled",
+			holder: &WorkspaceLock{Name: "app", Transaction: "other", Host: hostname, Pid: os.Getppid()},
+			wait:   true,
+			err:    context.DeadlineExceeded.Error(),
+			lock:   "app",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(wait bool) { waitForLock = wait }(waitForLock)
+			waitForLock = tt.wait
+			defer func(dir string) { polycrateRuntimeDir = dir }(polycrateRuntimeDir)
+			polycrateRuntimeDir = t.TempDir()
+
+			tx := newTestTransaction()
+			defer tx.CancelFunc()
+			ctx, cancel := context.WithTimeout(tx.Context, 100*time.Millisecond)
+			defer cancel()
+			tx.Context = ctx
+
+			w := &Workspace{Name: "ws", LocalPath: t.TempDir()}
+			w.Config.Lock = tt.scope
+			action := &Action{Name: "deploy", Block: "app", block: &Block{Name: "app"}}
+
+			if tt.holder != nil {
+				if err := w.writeLock(tt.holder); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			lock, err := w.AcquireLock(tx, action)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				// The lock of the holder is left alone
+				if holder, err := w.readLock(tt.lock); err != nil || holder.Transaction != tt.holder.Transaction {
+					t.Errorf("expected the lock to be held by %s, got %v (%v)", tt.holder.Transaction, holder, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if lock.Name != tt.lock || lock.Action != "app:deploy" || lock.Pid != os.Getpid() || lock.Transaction != tx.TXID.String() {
+				t.Errorf("unexpected lock: %+v", lock)
+			}
+			held, err := w.readLock(tt.lock)
+			if err != nil || held.Transaction != lock.Transaction {
+				t.Fatalf("expected the lock file to be held by the transaction, got %v (%v)", held, err)
+			}
+
+			if err := w.ReleaseLock(tx, lock); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.readLock(tt.lock); !os.IsNotExist(err) {
+				t.Errorf("expected the lock to be released, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAcquireLockWaitsForThisProcess(t *testing.T) {
+	defer func(dir string) { polycrateRuntimeDir = dir }(polycrateRuntimeDir)
+	polycrateRuntimeDir = t.TempDir()
+
+	tx := newTestTransaction()
+	defer tx.CancelFunc()
+
+	w := &Workspace{Name: "ws", LocalPath: t.TempDir()}
+	block := &Block{Name: "app"}
+	first := &Action{Name: "deploy", Block: "app", block: block}
+	second := &Action{Name: "migrate", Block: "app", block: block}
+
+	lock, err := w.AcquireLock(tx, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Parallel steps of a workflow wait for each other even without --wait
+	other := newTestTransaction()
+	defer other.CancelFunc()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		w.ReleaseLock(tx, lock)
+	}()
+
+	acquired, err := w.AcquireLock(other, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acquired.Transaction != other.TXID.String() || acquired.Action != "app:migrate" {
+		t.Errorf("unexpected lock: %+v", acquired)
+	}
+}
+
+func TestWriteLock(t *testing.T) {
+	defer func(dir string) { polycrateRuntimeDir = dir }(polycrateRuntimeDir)
+	polycrateRuntimeDir = t.TempDir()
+
+	w := &Workspace{Name: "ws", LocalPath: t.TempDir()}
+	if err := w.writeLock(&WorkspaceLock{Name: "app", Transaction: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.writeLock(&WorkspaceLock{Name: "app", Transaction: "second"}); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("expected the held lock to fail with ErrExist, got %v", err)
+	}
+
+	if lock, err := w.readLock("app"); err != nil || lock.Transaction != "first" {
+		t.Errorf("expected the lock to be held by first, got %v (%v)", lock, err)
+	}
+
+	// No temporary files are left behind
+	files, err := os.ReadDir(w.getLocksPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "app.lock" {
+		t.Errorf("expected only the lock file, got %v", files)
+	}
+
+	// Another checkout of the workspace has its own locks
+	other := &Workspace{Name: "ws", LocalPath: t.TempDir()}
+	if other.getLocksPath() == w.getLocksPath() {
+		t.Errorf("expected separate locks for %s and %s", w.LocalPath, other.LocalPath)
+	}
+}
+
+func TestBreakStaleLock(t *testing.T) {
+	defer func(dir string) { polycrateRuntimeDir = dir }(polycrateRuntimeDir)
+	polycrateRuntimeDir = t.TempDir()
+
+	w := &Workspace{Name: "ws", LocalPath: t.TempDir()}
+	stale := &WorkspaceLock{Name: "app", Transaction: "stale"}
+
+	// The lock has been acquired by another process in the meantime
+	if err := w.writeLock(&WorkspaceLock{Name: "app", Transaction: "fresh"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.breakStaleLock(stale); err != nil {
+		t.Fatal(err)
+	}
+	if lock, err := w.readLock("app"); err != nil || lock.Transaction != "fresh" {
+		t.Fatalf("expected the fresh lock to be kept, got %v (%v)", lock, err)
+	}
+
+	// The lock is still held by the stale holder
+	if err := os.Remove(w.getLockPath("app")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.writeLock(stale); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.breakStaleLock(stale); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.readLock("app"); !os.IsNotExist(err) {
+		t.Errorf("expected the stale lock to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,497 @@
+/*
+Copyright © 2021 Fabian Peter <fp@ayedo.de>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"gopkg.in/yaml.v3"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sYaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+	sigsYaml "sigs.k8s.io/yaml"
+
+	// Import to initialize client auth plugins.
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+)
+
+// Name of the field manager used for server-side apply
+const ManifestsFieldManager = "polycrate"
+
+// Label that tells the resources of different manifests actions of a block apart
+const ManifestsActionLabel = "actions.polycrate.io/name"
+
+// Labels of a block that are added to its manifests and used to find resources to prune
+var manifestsPruneLabels = []string{
+	"polycrate.io/managed-by",
+	"workspaces.polycrate.io/name",
+	"blocks.polycrate.io/name",
+}
+
+type ManifestsClient struct {
+	dynamic dynamic.Interface
+	mapper  *restmapper.DeferredDiscoveryRESTMapper
+	// Namespace of resources that don't specify one
+	namespace string
+}
+
+func NewManifestsClient(kubeconfigPath string, namespace string) (*ManifestsClient, error) {
+	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfigPath},
+		&clientcmd.ConfigOverrides{},
+	)
+
+	config, err := loader.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig %s: %s", kubeconfigPath, err)
+	}
+
+	if namespace == "" {
+		if namespace, _, err = loader.Namespace(); err != nil {
+			return nil, err
+		}
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	cachedDiscoveryClient := memory.NewMemCacheClient(discoveryClient)
+
+	return &ManifestsClient{
+		dynamic:   dynamicClient,
+		mapper:    restmapper.NewDeferredDiscoveryRESTMapper(cachedDiscoveryClient),
+		namespace: namespace,
+	}, nil
+}
+
+// Returns the API resource of the object
+// Namespaced objects without a namespace get the default namespace of the client
+func (c *ManifestsClient) resourceFor(obj *unstructured.Unstructured) (dynamic.ResourceInterface, error) {
+	gvk := obj.GroupVersionKind()
+	mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find resource of %s: %s", manifestRef(obj), err)
+	}
+
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		if obj.GetNamespace() == "" {
+			obj.SetNamespace(c.namespace)
+		}
+		return c.dynamic.Resource(mapping.Resource).Namespace(obj.GetNamespace()), nil
+	}
+	return c.dynamic.Resource(mapping.Resource), nil
+}
+
+// Applies the object with server-side apply and returns the result
+func (c *ManifestsClient) Apply(ctx context.Context, obj *unstructured.Unstructured, dryRun bool) (*unstructured.Unstructured, error) {
+	resource, err := c.resourceFor(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	options := metav1.ApplyOptions{
+		FieldManager: ManifestsFieldManager,
+		Force:        true,
+	}
+	if dryRun {
+		options.DryRun = []string{metav1.DryRunAll}
+	}
+
+	result, err := resource.Apply(ctx, obj.GetName(), obj, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply %s: %s", manifestRef(obj), err)
+	}
+
+	// Resources of new CRDs can only be mapped after discovery has been refreshed
+	if !dryRun && obj.GetKind() == "CustomResourceDefinition" {
+		c.mapper.Reset()
+	}
+	return result, nil
+}
+
+// Returns a unified diff between the live object and the result of applying it
+func (c *ManifestsClient) Diff(ctx context.Context, obj *unstructured.Unstructured) (string, error) {
+	live := ""
+	if resource, err := c.resourceFor(obj); err == nil {
+		current, err := resource.Get(ctx, obj.GetName(), metav1.GetOptions{})
+		if err == nil {
+			if live, err = formatManifest(current); err != nil {
+				return "", err
+			}
+		} else if !k8sErrors.IsNotFound(err) {
+			return "", fmt.Errorf("failed to get %s: %s", manifestRef(obj), err)
+		}
+	}
+
+	merged, err := c.Apply(ctx, obj.DeepCopy(), true)
+	if err != nil {
+		// The dry-run fails if the object depends on objects that haven't been applied yet,
+		// e.g. its namespace or CRD. The object itself is the closest we get then
+		merged = obj
+	}
+	desired, err := formatManifest(merged)
+	if err != nil {
+		return "", err
+	}
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitManifestLines(live),
+		B:        splitManifestLines(desired),
+		FromFile: "live/" + manifestRef(obj),
+		ToFile:   "merged/" + manifestRef(obj),
+		Context:  3,
+	})
+}
+
+// Returns the resources that match the selector but are not part of objects
+// Only the kinds of objects are searched in the namespaces of objects, so resources of kinds
+// or in namespaces that have been removed from the manifests entirely are not pruned
+func (c *ManifestsClient) FindOrphans(ctx context.Context, selector string, objects []*unstructured.Unstructured) ([]*unstructured.Unstructured, error) {
+	applied := map[string]bool{}
+	mappings := map[string]*meta.RESTMapping{}
+	namespaces := map[string]bool{}
+	for _, obj := range objects {
+		applied[manifestRef(obj)] = true
+
+		gvk := obj.GroupVersionKind()
+		mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			// Resources of unknown kinds can't exist
+			continue
+		}
+		mappings[mapping.Resource.String()] = mapping
+
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			namespace := obj.GetNamespace()
+			if namespace == "" {
+				namespace = c.namespace
+			}
+			namespaces[namespace] = true
+		}
+	}
+
+	resources := []string{}
+	for resource := range mappings {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+
+	namespaceNames := []string{}
+	for namespace := range namespaces {
+		namespaceNames = append(namespaceNames, namespace)
+	}
+	sort.Strings(namespaceNames)
+
+	orphans := []*unstructured.Unstructured{}
+	for _, resource := range resources {
+		mapping := mappings[resource]
+
+		clients := []dynamic.ResourceInterface{c.dynamic.Resource(mapping.Resource)}
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			clients = []dynamic.ResourceInterface{}
+			for _, namespace := range namespaceNames {
+				clients = append(clients, c.dynamic.Resource(mapping.Resource).Namespace(namespace))
+			}
+		}
+
+		for _, client := range clients {
+			list, err := client.List(ctx, metav1.ListOptions{LabelSelector: selector})
+			if err != nil {
+				if k8sErrors.IsNotFound(err) || k8sErrors.IsForbidden(err) || k8sErrors.IsMethodNotSupported(err) {
+					continue
+				}
+				return nil, fmt.Errorf("failed to list %s: %s", mapping.Resource.Resource, err)
+			}
+
+			for i := range list.Items {
+				item := &list.Items[i]
+				if !applied[manifestRef(item)] {
+					orphans = append(orphans, item)
+				}
+			}
+		}
+	}
+	return orphans, nil
+}
+
+// Deletes the object; objects that are already gone are ignored
+func (c *ManifestsClient) Delete(ctx context.Context, obj *unstructured.Unstructured) error {
+	resource, err := c.resourceFor(obj)
+	if err != nil {
+		return err
+	}
+
+	propagation := metav1.DeletePropagationBackground
+	err = resource.Delete(ctx, obj.GetName(), metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete %s: %s", manifestRef(obj), err)
+	}
+	return nil
+}
+
+// Loads the manifests of the action and the extra manifests of its block
+// The labels of the block are added to every manifest so resources that have been removed can be pruned
+func (a *Action) loadManifests() ([]*unstructured.Unstructured, error) {
+	block := a.block
+	objects := []*unstructured.Unstructured{}
+
+	manifestsPath := filepath.Join(block.Workdir.LocalPath, a.Manifests)
+	err := filepath.WalkDir(manifestsPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		switch filepath.Ext(path) {
+		case ".yml", ".yaml", ".json":
+		default:
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		decoded, err := decodeManifests(f)
+		if err != nil {
+			return fmt.Errorf("failed to decode manifests in %s: %s", path, err)
+		}
+		objects = append(objects, decoded...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := block.getConfig()
+	if err != nil {
+		return nil, err
+	}
+	for _, manifest := range config.ExtraManifests {
+		data, err := yaml.Marshal(manifest)
+		if err != nil {
+			return nil, err
+		}
+
+		decoded, err := decodeManifests(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode extra manifests of block '%s': %s", block.Name, err)
+		}
+		objects = append(objects, decoded...)
+	}
+
+	for _, obj := range objects {
+		objectLabels := obj.GetLabels()
+		if objectLabels == nil {
+			objectLabels = map[string]string{}
+		}
+		for _, label := range manifestsPruneLabels {
+			objectLabels[label] = block.Labels[label]
+		}
+		objectLabels[ManifestsActionLabel] = a.Name
+		obj.SetLabels(objectLabels)
+	}
+
+	// Namespaces and CRDs must exist before the objects that use them
+	sort.SliceStable(objects, func(i, j int) bool {
+		return manifestPriority(objects[i]) < manifestPriority(objects[j])
+	})
+	return objects, nil
+}
+
+// Returns the label selector matching the resources applied by the action
+func (a *Action) getManifestsSelector() string {
+	selector := labels.Set{
+		ManifestsActionLabel: a.Name,
+	}
+	for _, label := range manifestsPruneLabels {
+		selector[label] = a.block.Labels[label]
+	}
+	return selector.String()
+}
+
+// Applies the manifests of the action to the kubeconfig of its block with server-side apply
+// and prunes resources that have been removed from the manifests
+// A diff of all changes is shown first; with --check nothing is applied
+func (a *Action) applyManifests(ctx context.Context, tx *PolycrateTransaction) error {
+	block := a.block
+
+	kubeconfig := block.getKubeconfig(tx)
+	if kubeconfig == nil || !kubeconfig.exists {
+		return fmt.Errorf("block '%s' has no kubeconfig to apply the manifests of action '%s' to", block.Name, a.Name)
+	}
+
+	config, err := block.getConfig()
+	if err != nil {
+		return err
+	}
+
+	objects, err := a.loadManifests()
+	if err != nil {
+		return err
+	}
+
+	client, err := NewManifestsClient(kubeconfig.LocalPath, config.Namespace)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objects {
+		diff, err := client.Diff(ctx, obj)
+		if err != nil {
+			return err
+		}
+		fmt.Print(diff)
+	}
+
+	orphans, err := client.FindOrphans(ctx, a.getManifestsSelector(), objects)
+	if err != nil {
+		return err
+	}
+	for _, orphan := range orphans {
+		fmt.Printf("--- live/%s\n+++ pruned\n", manifestRef(orphan))
+	}
+
+	if checkMode {
+		tx.Log.Infof("Not applying manifests (--check)")
+		return nil
+	}
+
+	for _, obj := range objects {
+		if _, err := client.Apply(ctx, obj, false); err != nil {
+			return err
+		}
+		tx.Log.Infof("Applied %s", manifestRef(obj))
+	}
+
+	for _, orphan := range orphans {
+		if err := client.Delete(ctx, orphan); err != nil {
+			return err
+		}
+		tx.Log.Infof("Pruned %s", manifestRef(orphan))
+	}
+	return nil
+}
+
+// Decodes a stream of YAML or JSON documents; lists are expanded to their items
+func decodeManifests(r io.Reader) ([]*unstructured.Unstructured, error) {
+	objects := []*unstructured.Unstructured{}
+
+	decoder := k8sYaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		raw := runtime.RawExtension{}
+		if err := decoder.Decode(&raw); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		data := bytes.TrimSpace(raw.Raw)
+		if len(data) == 0 || string(data) == "null" {
+			continue
+		}
+
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(data); err != nil {
+			return nil, err
+		}
+
+		if obj.IsList() {
+			list, err := obj.ToList()
+			if err != nil {
+				return nil, err
+			}
+			for i := range list.Items {
+				objects = append(objects, &list.Items[i])
+			}
+			continue
+		}
+		objects = append(objects, obj)
+	}
+	return objects, nil
+}
+
+// Renders the object as YAML without the fields that are managed by the server
+func formatManifest(obj *unstructured.Unstructured) (string, error) {
+	obj = obj.DeepCopy()
+	for _, field := range []string{"managedFields", "resourceVersion", "uid", "generation", "creationTimestamp"} {
+		unstructured.RemoveNestedField(obj.Object, "metadata", field)
+	}
+	unstructured.RemoveNestedField(obj.Object, "status")
+
+	data, err := sigsYaml.Marshal(obj.Object)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Returns a reference to the object like group/Kind/namespace/name
+func manifestRef(obj *unstructured.Unstructured) string {
+	gvk := obj.GroupVersionKind()
+	parts := []string{}
+	if gvk.Group != "" {
+		parts = append(parts, gvk.Group)
+	}
+	parts = append(parts, gvk.Kind)
+	if obj.GetNamespace() != "" {
+		parts = append(parts, obj.GetNamespace())
+	}
+	parts = append(parts, obj.GetName())
+	return strings.Join(parts, "/")
+}
+
+func splitManifestLines(manifest string) []string {
+	if manifest == "" {
+		return []string{}
+	}
+	return difflib.SplitLines(manifest)
+}
+
+func manifestPriority(obj *unstructured.Unstructured) int {
+	switch obj.GetKind() {
+	case "Namespace":
+		return 0
+	case "CustomResourceDefinition":
+		return 1
+	}
+	return 2
+}
@@ -0,0 +1,129 @@
+/*
+Copyright © 2021 Fabian Peter <fp@ayedo.de>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery/cached/memory"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/restmapper"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// Returns a manifests client for a fake cluster with configmaps, secrets and namespaces
+func newTestManifestsClient(objects ...runtime.Object) *ManifestsClient {
+	discovery := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{
+		Resources: []*metav1.APIResourceList{
+			{
+				GroupVersion: "v1",
+				APIResources: []metav1.APIResource{
+					{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: metav1.Verbs{"list", "delete"}},
+					{Name: "secrets", Kind: "Secret", Namespaced: true, Verbs: metav1.Verbs{"list", "delete"}},
+					{Name: "namespaces", Kind: "Namespace", Verbs: metav1.Verbs{"list", "delete"}},
+				},
+			},
+		},
+	}}
+	cachedDiscovery := memory.NewMemCacheClient(discovery)
+
+	listKinds := map[schema.GroupVersionResource]string{
+		{Version: "v1", Resource: "configmaps"}: "ConfigMapList",
+		{Version: "v1", Resource: "secrets"}:    "SecretList",
+		{Version: "v1", Resource: "namespaces"}: "NamespaceList",
+	}
+
+	return &ManifestsClient{
+		dynamic:   fakedynamic.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objects...),
+		mapper:    restmapper.NewDeferredDiscoveryRESTMapper(cachedDiscovery),
+		namespace: "default",
+	}
+}
+
+func newTestManifest(kind string, namespace string, name string, labels map[string]string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	obj.SetLabels(labels)
+	return obj
+}
+
+func TestFindOrphans(t *testing.T) {
+	owned := map[string]string{
+		ManifestsActionLabel:           "deploy",
+		"polycrate.io/managed-by":      "cli",
+		"workspaces.polycrate.io/name": "ws",
+		"blocks.polycrate.io/name":     "app",
+	}
+	otherWorkspace := map[string]string{
+		ManifestsActionLabel:           "deploy",
+		"polycrate.io/managed-by":      "cli",
+		"workspaces.polycrate.io/name": "other",
+		"blocks.polycrate.io/name":     "app",
+	}
+
+	client := newTestManifestsClient(
+		newTestManifest("ConfigMap", "apps", "kept", owned),
+		newTestManifest("ConfigMap", "apps", "removed", owned),
+		newTestManifest("ConfigMap", "default", "removed", owned),
+		newTestManifest("ConfigMap", "apps", "other-workspace", otherWorkspace),
+		newTestManifest("ConfigMap", "unmanaged", "removed", owned),
+		newTestManifest("Secret", "apps", "unmanaged-kind", owned),
+	)
+
+	action := newTestAction()
+	action.block.Labels = map[string]string{
+		"polycrate.io/managed-by":      "cli",
+		"workspaces.polycrate.io/name": "ws",
+		"blocks.polycrate.io/name":     "app",
+	}
+	selector := action.getManifestsSelector()
+	if !strings.Contains(selector, "workspaces.polycrate.io/name=ws") {
+		t.Errorf("expected the selector to contain the workspace, got %s", selector)
+	}
+
+	objects := []*unstructured.Unstructured{
+		newTestManifest("ConfigMap", "apps", "kept", owned),
+		// Objects without a namespace are applied to the default namespace
+		newTestManifest("ConfigMap", "", "added", owned),
+	}
+
+	orphans, err := client.FindOrphans(context.Background(), selector, objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refs := []string{}
+	for _, orphan := range orphans {
+		refs = append(refs, manifestRef(orphan))
+	}
+	sort.Strings(refs)
+
+	expected := []string{"ConfigMap/apps/removed", "ConfigMap/default/removed"}
+	if strings.Join(refs, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected orphans %v, got %v", expected, refs)
+	}
+}
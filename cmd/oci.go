@@ -18,6 +18,7 @@ import (
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
 )
 
 func OCIImageExists(name string) bool {
@@ -25,6 +26,46 @@ func OCIImageExists(name string) bool {
 	return err == nil
 }
 
+// Returns the tags of the repository (registry/name)
+func ListOCITags(repository string) ([]string, error) {
+	return crane.ListTags(repository)
+}
+
+// Returns the digest of the image in the registry
+func GetOCIDigest(name string) (string, error) {
+	return crane.Digest(name)
+}
+
+// Returns the labels of the config of the image in the registry
+func GetOCILabels(name string) (map[string]string, error) {
+	data, err := crane.Config(name)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := v1.ParseConfigFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return config.Config.Labels, nil
+}
+
+// Returns the reference of the image in the registry; the default registry is used if none is given
+// Pulling, signing and verifying a block all use this reference, so they refer to the same repository
+// imageTag can be a digest (sha256:...) as well
+func getOCIReference(registryUrl string, imageName string, imageTag string) (name.Reference, error) {
+	registryBase := polycrate.Config.Registry.Url
+
+	if registryUrl != "" {
+		registryBase = registryUrl
+	}
+
+	if strings.HasPrefix(imageTag, "sha256:") {
+		return name.NewDigest(strings.Join([]string{registryBase, strings.Join([]string{imageName, imageTag}, "@")}, "/"))
+	}
+	return name.NewTag(strings.Join([]string{registryBase, strings.Join([]string{imageName, imageTag}, ":")}, "/"))
+}
+
 func PullOCIImage(ctx context.Context, name string) (v1.Image, error) {
 	img, err := crane.Pull(name)
 	if err != nil {
@@ -33,7 +74,9 @@ func PullOCIImage(ctx context.Context, name string) (v1.Image, error) {
 	return img, nil
 }
 
-func WrapOCIImage(ctx context.Context, path string, registryUrl string, imageName string, imageTag string, labels map[string]string) error {
+// Packages the directory as image and pushes it to the registry
+// If a signer is given, a signature artifact of the image is pushed next to it
+func WrapOCIImage(ctx context.Context, path string, registryUrl string, imageName string, imageTag string, labels map[string]string, signer ssh.Signer) error {
 
 	var tag name.Tag
 	var latestTag name.Tag
@@ -141,6 +184,19 @@ func WrapOCIImage(ctx context.Context, path string, registryUrl string, imageNam
 		}
 	}
 
+	if signer != nil {
+		digest, err := newImg.Digest()
+		if err != nil {
+			return err
+		}
+
+		log = log.WithField("digest", digest.String())
+		log.Debugf("Signing image")
+		if err := SignOCIImage(ctx, tag.Context(), digest.String(), signer); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -248,17 +304,11 @@ func extract(img v1.Image, w io.Writer) error {
 	return nil
 }
 
-func UnwrapOCIImage(ctx context.Context, path string, registryUrl string, imageName string, imageTag string) error {
-	registryBase := polycrate.Config.Registry.Url
-
-	if registryUrl != "" {
-		registryBase = registryUrl
-	}
-
-	localImageTag := strings.Join([]string{imageName, imageTag}, ":")
-	tag, err := name.NewTag(strings.Join([]string{registryBase, localImageTag}, "/"))
+// Pulls the image and unpacks it to path. Returns the digest of the image
+func UnwrapOCIImage(ctx context.Context, path string, registryUrl string, imageName string, imageTag string) (string, error) {
+	tag, err := getOCIReference(registryUrl, imageName, imageTag)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	log := log.WithField("image", tag.String())
@@ -267,12 +317,17 @@ func UnwrapOCIImage(ctx context.Context, path string, registryUrl string, imageN
 
 	img, err := PullOCIImage(ctx, tag.String())
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	digest, err := img.Digest()
+	if err != nil {
+		return "", err
 	}
 
 	f, err := polycrate.getTempFile(ctx, strings.Join([]string{strings.Replace(imageName, "/", "-", -1), "tgz"}, "."))
 	if err != nil {
-		return err
+		return "", err
 	}
 	//defer f.Close()
 	//defer os.Remove(f.Name())
@@ -293,7 +348,7 @@ func UnwrapOCIImage(ctx context.Context, path string, registryUrl string, imageN
 	// 	return err
 	// }
 	if _, err := io.Copy(f, fs); err != nil {
-		return err
+		return "", err
 	}
 
 	//log.Debugf("Unpacking image to %s", path)
@@ -301,15 +356,15 @@ func UnwrapOCIImage(ctx context.Context, path string, registryUrl string, imageN
 	log.Debugf("Removing existing block")
 	err = os.RemoveAll(path)
 	if err != nil {
-		return err
+		return "", err
 	}
 	log.Debugf("Unpacking image")
 	err = Untar(f.Name(), path)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	return nil
+	return digest.String(), nil
 }
 
 func layerFromDir(root string, targetPath string) (v1.Layer, error) {
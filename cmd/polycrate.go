@@ -89,6 +89,8 @@ type PolycrateConfig struct {
 	CheckUpdates bool               `yaml:"check_updates,omitempty" mapstructure:"check_updates,omitempty" json:"check_updates,omitempty"`
 	AutoCommit   bool               `yaml:"auto_commit,omitempty" mapstructure:"auto_commit,omitempty" json:"auto_commit,omitempty"`
 	Experimental ExperimentalConfig `yaml:"experimental,omitempty" mapstructure:"experimental,omitempty" json:"experimental,omitempty"`
+	// Keys trusted to sign pulled blocks in every workspace
+	Trust TrustPolicy `yaml:"trust,omitempty" mapstructure:"trust,omitempty" json:"trust,omitempty"`
 	//Workspace PolycrateWorkspaceDefaults `yaml:"workspace,omitempty" mapstructure:"workspace,omitempty" json:"workspace,omitempty"`
 }
 
@@ -107,13 +109,27 @@ type PolycrateEvent struct {
 	Date        string               `yaml:"date,omitempty" mapstructure:"date,omitempty" json:"date,omitempty"`
 	Snapshot    WorkspaceSnapshot    `yaml:"snapshot,omitempty" mapstructure:"snapshot,omitempty" json:"snapshot,omitempty"`
 	Transaction string               `yaml:"transaction,omitempty" mapstructure:"transaction,omitempty" json:"transaction,omitempty"`
+	Parent      string               `yaml:"parent,omitempty" mapstructure:"parent,omitempty" json:"parent,omitempty"`
+	Attempts    []PolycrateAttempt   `yaml:"attempts,omitempty" mapstructure:"attempts,omitempty" json:"attempts,omitempty"`
 	Version     string               `yaml:"version,omitempty" mapstructure:"version,omitempty" json:"version,omitempty"`
 	CommitSha   string               `yaml:"commit_sha,omitempty" mapstructure:"commit_sha,omitempty" json:"commit_sha,omitempty"`
 	Output      string               `yaml:"output,omitempty" mapstructure:"output,omitempty" json:"output,omitempty"`
+	Outputs     map[string]string    `yaml:"outputs,omitempty" mapstructure:"outputs,omitempty" json:"outputs,omitempty"`
+	CacheKeys   map[string]string    `yaml:"cache_keys,omitempty" mapstructure:"cache_keys,omitempty" json:"cache_keys,omitempty"`
 	Config      WorkspaceEventConfig `yaml:"config,omitempty" mapstructure:"config,omitempty" json:"config,omitempty"`
 	Message     string               `yaml:"message,omitempty" mapstructure:"message,omitempty" json:"message,omitempty"`
 }
 
+// A single attempt of an action or step that has a retry or timeout policy
+type PolycrateAttempt struct {
+	Name     string `yaml:"name,omitempty" mapstructure:"name,omitempty" json:"name,omitempty"`
+	Attempt  int    `yaml:"attempt,omitempty" mapstructure:"attempt,omitempty" json:"attempt,omitempty"`
+	ExitCode int    `yaml:"exit_code,omitempty" mapstructure:"exit_code,omitempty" json:"exit_code,omitempty"`
+	Error    string `yaml:"error,omitempty" mapstructure:"error,omitempty" json:"error,omitempty"`
+	TimedOut bool   `yaml:"timed_out,omitempty" mapstructure:"timed_out,omitempty" json:"timed_out,omitempty"`
+	Duration string `yaml:"duration,omitempty" mapstructure:"duration,omitempty" json:"duration,omitempty"`
+}
+
 type Webhook struct {
 	Endpoint string            `yaml:"endpoint,omitempty" mapstructure:"endpoint,omitempty" json:"endpoint,omitempty"`
 	Labels   map[string]string `yaml:"labels,omitempty" mapstructure:"labels,omitempty" json:"labels,omitempty"`
@@ -140,21 +156,29 @@ type PolycrateTransactionTask struct {
 type PolycrateTransaction struct {
 	Context     context.Context
 	TXID        uuid.UUID `yaml:"txid,omitempty" mapstructure:"txid,omitempty" json:"txid,omitempty"`
+	Parent      uuid.UUID `yaml:"parent,omitempty" mapstructure:"parent,omitempty" json:"parent,omitempty"`
 	CancelFunc  func()
 	Log         PolycrateLog
-	RuntimeDir  string            `yaml:"runtime_dir,omitempty" mapstructure:"runtime_dir,omitempty" json:"runtime_dir,omitempty"`
-	Command     string            `yaml:"command,omitempty" mapstructure:"command,omitempty" json:"command,omitempty"`
-	UserEmail   string            `yaml:"user_email,omitempty" mapstructure:"user_email,omitempty" json:"user_email,omitempty"`
-	UserName    string            `yaml:"user_name,omitempty" mapstructure:"user_name,omitempty" json:"user_name,omitempty"`
-	Date        string            `yaml:"date,omitempty" mapstructure:"date,omitempty" json:"date,omitempty"`
-	Transaction uuid.UUID         `yaml:"transaction,omitempty" mapstructure:"transaction,omitempty" json:"transaction,omitempty"`
-	Version     string            `yaml:"version,omitempty" mapstructure:"version,omitempty" json:"version,omitempty"`
-	CommitSha   string            `yaml:"commit_sha,omitempty" mapstructure:"commit_sha,omitempty" json:"commit_sha,omitempty"`
-	ExitCode    int               `yaml:"exit_code,omitempty" mapstructure:"exit_code,omitempty" json:"exit_code,omitempty"`
-	Output      string            `yaml:"output,omitempty" mapstructure:"output,omitempty" json:"output,omitempty"`
-	Snapshot    WorkspaceSnapshot `yaml:"snapshot,omitempty" mapstructure:"snapshot,omitempty" json:"snapshot,omitempty"`
-	Job         func(tx *PolycrateTransaction) error
-	Tasks       []*PolycrateTransactionTask
+	RuntimeDir  string             `yaml:"runtime_dir,omitempty" mapstructure:"runtime_dir,omitempty" json:"runtime_dir,omitempty"`
+	Command     string             `yaml:"command,omitempty" mapstructure:"command,omitempty" json:"command,omitempty"`
+	UserEmail   string             `yaml:"user_email,omitempty" mapstructure:"user_email,omitempty" json:"user_email,omitempty"`
+	UserName    string             `yaml:"user_name,omitempty" mapstructure:"user_name,omitempty" json:"user_name,omitempty"`
+	Date        string             `yaml:"date,omitempty" mapstructure:"date,omitempty" json:"date,omitempty"`
+	Transaction uuid.UUID          `yaml:"transaction,omitempty" mapstructure:"transaction,omitempty" json:"transaction,omitempty"`
+	Version     string             `yaml:"version,omitempty" mapstructure:"version,omitempty" json:"version,omitempty"`
+	CommitSha   string             `yaml:"commit_sha,omitempty" mapstructure:"commit_sha,omitempty" json:"commit_sha,omitempty"`
+	ExitCode    int                `yaml:"exit_code,omitempty" mapstructure:"exit_code,omitempty" json:"exit_code,omitempty"`
+	Output      string             `yaml:"output,omitempty" mapstructure:"output,omitempty" json:"output,omitempty"`
+	Snapshot    WorkspaceSnapshot  `yaml:"snapshot,omitempty" mapstructure:"snapshot,omitempty" json:"snapshot,omitempty"`
+	Attempts    []PolycrateAttempt `yaml:"attempts,omitempty" mapstructure:"attempts,omitempty" json:"attempts,omitempty"`
+	Outputs     map[string]string  `yaml:"outputs,omitempty" mapstructure:"outputs,omitempty" json:"outputs,omitempty"`
+	Labels      map[string]string  `yaml:"labels,omitempty" mapstructure:"labels,omitempty" json:"labels,omitempty"`
+	// Cache keys of the actions that succeeded in the transaction, keyed by block:action
+	CacheKeys map[string]string `yaml:"cache_keys,omitempty" mapstructure:"cache_keys,omitempty" json:"cache_keys,omitempty"`
+	Job       func(tx *PolycrateTransaction) error
+	Tasks     []*PolycrateTransactionTask
+	// Number of containers started in the transaction
+	containers int
 	// One of:
 	// - created
 	// - running
@@ -175,8 +199,19 @@ type Prompt struct {
 	Message string `yaml:"message,omitempty" mapstructure:"message,omitempty" json:"message,omitempty"`
 }
 
+// Prompts read from stdin, so only one of them may be active at a time
+// (e.g. when workflow steps run in parallel)
+var promptLock sync.Mutex
+
 func (p *Prompt) Validate() bool {
-	return force || prompter.YN(p.Message, false)
+	if force {
+		return true
+	}
+
+	promptLock.Lock()
+	defer promptLock.Unlock()
+
+	return prompter.YN(p.Message, false)
 
 }
 
@@ -194,6 +229,18 @@ func NewEvent(tx *PolycrateTransaction) *PolycrateEvent {
 		Date:      tx.Date,
 		Output:    tx.Output,
 		Snapshot:  tx.Snapshot,
+		Attempts:  tx.Attempts,
+		Outputs:   tx.Outputs,
+		CacheKeys: tx.CacheKeys,
+	}
+
+	if tx.Parent != uuid.Nil {
+		event.Parent = tx.Parent.String()
+	}
+
+	// Labels of the transaction never override the labels set by polycrate
+	if err := event.MergeInLabels(tx.Labels); err != nil {
+		tx.Log.Warnf("Failed to add labels to event: %s", err)
 	}
 
 	if tx.Snapshot.Workspace != nil {
@@ -416,6 +463,8 @@ func (p *Polycrate) Load(ctx context.Context) error {
 	// Register the custom validators to the global validator variable
 	validate.RegisterValidation("metadata_name", validateMetadataName)
 	validate.RegisterValidation("block_name", validateBlockName)
+	validate.RegisterValidation("duration", validateDuration)
+	validate.RegisterValidation("schedule", validateSchedule)
 
 	if _, err := os.Stat(polycrateConfigFilePath); os.IsNotExist(err) {
 		// Seems config wasn't found
@@ -454,6 +503,7 @@ func (p *Polycrate) DeleteTransaction(TXID uuid.UUID) error {
 	for i := len(p.Transactions) - 1; i >= 0; i-- {
 		if p.Transactions[i].TXID == TXID {
 			p.Transactions[i] = p.Transactions[len(p.Transactions)-1]
+			p.Transactions = p.Transactions[:len(p.Transactions)-1]
 			deleted = true
 		}
 	}
@@ -519,7 +569,42 @@ func (p *Polycrate) UnregisterTransaction(transaction *PolycrateTransaction) err
 }
 
 func (p *Polycrate) Transaction() *PolycrateTransaction {
-	ctx, cancel := context.WithCancel(context.Background())
+	return p.newTransaction(context.Background())
+}
+
+// Creates a transaction that belongs to a parent transaction (e.g. a workflow step)
+// It gets cancelled together with its parent and references the parent's TXID in its event
+func (p *Polycrate) SubTransaction(parent *PolycrateTransaction) *PolycrateTransaction {
+	return p.SubTransactionWithContext(parent, parent.Context)
+}
+
+// Creates a transaction that belongs to a parent transaction but runs with the given context,
+// e.g. a single attempt of a step that is cancelled after the timeout of the attempt
+func (p *Polycrate) SubTransactionWithContext(parent *PolycrateTransaction, ctx context.Context) *PolycrateTransaction {
+	tx := p.newTransaction(ctx)
+	tx.Parent = parent.TXID
+	tx.Command = parent.Command
+	tx.Log.SetField("parent", parent.TXID.String())
+	return tx
+}
+
+// Creates a transaction that belongs to a parent transaction but isn't cancelled with it
+// It's cancelled after the given timeout instead, e.g. for cleanup after the parent has been interrupted
+func (p *Polycrate) DetachedSubTransaction(parent *PolycrateTransaction, timeout time.Duration) *PolycrateTransaction {
+	ctx, cancelTimeout := context.WithTimeout(context.Background(), timeout)
+
+	tx := p.SubTransactionWithContext(parent, ctx)
+
+	cancel := tx.CancelFunc
+	tx.CancelFunc = func() {
+		cancel()
+		cancelTimeout()
+	}
+	return tx
+}
+
+func (p *Polycrate) newTransaction(parentCtx context.Context) *PolycrateTransaction {
+	ctx, cancel := context.WithCancel(parentCtx)
 	txid := uuid.New()
 
 	TXIDKey := ContextKey("TXID")
@@ -575,16 +660,51 @@ func (tx *PolycrateTransaction) SetOutput(output string) *PolycrateTransaction {
 	tx.Output = output
 	return tx
 }
+func (tx *PolycrateTransaction) SetOutputs(outputs map[string]string) *PolycrateTransaction {
+	tx.Outputs = outputs
+	return tx
+}
+func (tx *PolycrateTransaction) SetCacheKey(action string, key string) *PolycrateTransaction {
+	if tx.CacheKeys == nil {
+		tx.CacheKeys = map[string]string{}
+	}
+	tx.CacheKeys[action] = key
+	return tx
+}
+func (tx *PolycrateTransaction) SetLabel(key string, value string) *PolycrateTransaction {
+	if tx.Labels == nil {
+		tx.Labels = map[string]string{}
+	}
+	tx.Labels[key] = value
+	return tx
+}
 func (tx *PolycrateTransaction) SetExitCode(exitCode int) *PolycrateTransaction {
 	tx.ExitCode = exitCode
 	return tx
 }
+
+// Returns the name of the next container of the transaction
+// Retries of an action and of its step run in the same transaction,
+// so every container after the first gets the number of its run appended
+func (tx *PolycrateTransaction) NewContainerName() string {
+	tx.containers++
+	if tx.containers == 1 {
+		return tx.TXID.String()
+	}
+	return fmt.Sprintf("%s-%d", tx.TXID.String(), tx.containers)
+}
 func (tx *PolycrateTransaction) SetJob(job func(*PolycrateTransaction) error) *PolycrateTransaction {
 	tx.Job = job
 	return tx
 }
 
 func (tx *PolycrateTransaction) Stop() *PolycrateTransaction {
+	// The graceful shutdown handler stops all transactions on CTRL-C,
+	// so the transaction might already have been stopped
+	if tx.Status == "stopped" {
+		return tx
+	}
+
 	tx.Log.Debug("Stopping transaction")
 	//log = log.WithField("txid", txid)
 
@@ -611,6 +731,95 @@ func (tx *PolycrateTransaction) Stop() *PolycrateTransaction {
 	tx.CancelFunc()
 	return tx
 }
+
+// Runs fn until it succeeds or the given number of retries is exhausted
+// Each attempt gets its own context derived from the transaction context that is cancelled
+// after the timeout, so commands started with it are interrupted when the attempt takes too long
+// Attempts are recorded in the transaction if a retry or timeout policy is configured
+func (tx *PolycrateTransaction) RunWithRetries(name string, retries int, retryDelay string, timeout string, fn func(ctx context.Context, attempt int) error) error {
+	if retries == 0 && timeout == "" {
+		return fn(tx.Context, 1)
+	}
+
+	var delay, _timeout time.Duration
+	var err error
+	if retryDelay != "" {
+		if delay, err = time.ParseDuration(retryDelay); err != nil {
+			return err
+		}
+	}
+	if timeout != "" {
+		if _timeout, err = time.ParseDuration(timeout); err != nil {
+			return err
+		}
+	}
+
+	ctx := tx.Context
+
+	for attempt := 1; ; attempt++ {
+		var attemptCtx context.Context
+		var cancel context.CancelFunc
+		if _timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, _timeout)
+		} else {
+			attemptCtx, cancel = context.WithCancel(ctx)
+		}
+
+		start := time.Now()
+		err = fn(attemptCtx, attempt)
+		timedOut := goErrors.Is(attemptCtx.Err(), context.DeadlineExceeded)
+		cancel()
+
+		if timedOut {
+			err = fmt.Errorf("%s timed out after %s", name, _timeout)
+		}
+
+		record := PolycrateAttempt{
+			Name:     name,
+			Attempt:  attempt,
+			ExitCode: tx.ExitCode,
+			TimedOut: timedOut,
+			Duration: time.Since(start).Round(time.Second).String(),
+		}
+		if err != nil {
+			record.Error = err.Error()
+		}
+		tx.Attempts = append(tx.Attempts, record)
+
+		// Don't retry if the attempt succeeded, has been skipped
+		// or the transaction itself has been cancelled
+		if err == nil || goErrors.Is(err, ErrConditionNotMet) || ctx.Err() != nil {
+			return err
+		}
+
+		if attempt > retries {
+			if retries > 0 {
+				return fmt.Errorf("%s failed after %d attempts: %w", name, attempt, err)
+			}
+			return err
+		}
+
+		tx.Log.Warnf("Attempt %d/%d of %s failed: %s. Retrying in %s", attempt, retries+1, name, err, delay)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+
+		delay = nextRetryDelay(delay)
+	}
+}
+
+// Returns the delay before the next retry; it doubles with every retry up to RetryMaxDelay
+func nextRetryDelay(delay time.Duration) time.Duration {
+	delay = delay * 2
+	if delay > RetryMaxDelay {
+		return RetryMaxDelay
+	}
+	return delay
+}
+
 func (tx *PolycrateTransaction) Run() (err error) {
 	if tx.Job != nil {
 		return tx.Job(tx)
@@ -780,6 +989,10 @@ func (p *Polycrate) CleanupRuntimeDir(tx *PolycrateTransaction) error {
 		return err
 	}
 	for _, d := range dir {
+		// Locks of running actions are kept
+		if d.Name() == WorkspaceLocksDir {
+			continue
+		}
 		tx.Log.Debugf("Removing directory at %s/%s", polycrateRuntimeDir, d.Name())
 		err := os.RemoveAll(filepath.Join([]string{polycrateRuntimeDir, d.Name()}...))
 		if err != nil {
@@ -1042,9 +1255,10 @@ func (p *Polycrate) PruneContainer(tx *PolycrateTransaction) error {
 	return nil
 }
 
-func (p *Polycrate) RunContainer(tx *PolycrateTransaction, mounts []string, env []string, ports []string, name string, labels []string, workdir string, image string, command []string) (int, string, error) {
+func (p *Polycrate) RunContainer(ctx context.Context, tx *PolycrateTransaction, mounts []string, env []string, ports []string, name string, labels []string, workdir string, image string, command []string, config ContainerConfig) (int, string, error) {
 
 	return RunContainer(
+		ctx,
 		tx,
 		image,
 		command,
@@ -1053,7 +1267,8 @@ func (p *Polycrate) RunContainer(tx *PolycrateTransaction, mounts []string, env
 		workdir,
 		ports,
 		labels,
-		name)
+		name,
+		config)
 }
 
 func (p *Polycrate) BuildContainer(ctx context.Context, contextDir string, dockerfile string, tags []string) (string, error) {
@@ -0,0 +1,217 @@
+/*
+Copyright © 2021 Fabian Peter <fp@ayedo.de>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	goErrors "errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMain(m *testing.M) {
+	// The custom validators are registered when polycrate is loaded
+	validate.RegisterValidation("metadata_name", validateMetadataName)
+	validate.RegisterValidation("block_name", validateBlockName)
+	validate.RegisterValidation("duration", validateDuration)
+	validate.RegisterValidation("schedule", validateSchedule)
+
+	os.Exit(m.Run())
+}
+
+// Returns a transaction that doesn't create a runtime directory
+func newTestTransaction() *PolycrateTransaction {
+	txid := uuid.New()
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ContextKey("TXID"), txid))
+	tx := &PolycrateTransaction{
+		Context:    ctx,
+		CancelFunc: cancel,
+		TXID:       txid,
+	}
+	tx.Log.Load(ctx)
+	return tx
+}
+
+func TestNextRetryDelay(t *testing.T) {
+	tests := []struct {
+		delay    time.Duration
+		expected time.Duration
+	}{
+		{0, 0},
+		{time.Second, 2 * time.Second},
+		{4 * time.Minute, 8 * time.Minute},
+		{6 * time.Minute, RetryMaxDelay},
+		{RetryMaxDelay, RetryMaxDelay},
+	}
+
+	for _, tt := range tests {
+		if got := nextRetryDelay(tt.delay); got != tt.expected {
+			t.Errorf("nextRetryDelay(%s): expected %s, got %s", tt.delay, tt.expected, got)
+		}
+	}
+}
+
+func TestRunWithRetries(t *testing.T) {
+	errFailed := goErrors.New("failed")
+
+	tests := []struct {
+		name     string
+		retries  int
+		timeout  string
+		fn       func(ctx context.Context, attempt int) error
+		attempts int
+		err      string
+		timedOut bool
+	}{
+		{
+			name:     "succeeds without policy",
+			fn:       func(ctx context.Context, attempt int) error { return nil },
+			attempts: 0,
+		},
+		{
+			name:    "succeeds after retries",
+			retries: 3,
+			fn: func(ctx context.Context, attempt int) error {
+				if attempt < 3 {
+					return errFailed
+				}
+				return nil
+			},
+			attempts: 3,
+		},
+		{
+			name:     "fails after all retries",
+			retries:  2,
+			fn:       func(ctx context.Context, attempt int) error { return errFailed },
+			attempts: 3,
+			err:      "test failed after 3 attempts: failed",
+		},
+		{
+			name:     "skipped attempts aren't retried",
+			retries:  2,
+			fn:       func(ctx context.Context, attempt int) error { return ErrConditionNotMet },
+			attempts: 1,
+			err:      ErrConditionNotMet.Error(),
+		},
+		{
+			name:    "attempts time out",
+			retries: 1,
+			timeout: "20ms",
+			fn: func(ctx context.Context, attempt int) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			attempts: 2,
+			err:      "test failed after 2 attempts: test timed out after 20ms",
+			timedOut: true,
+		},
+		{
+			name:    "timeout of a successful attempt",
+			timeout: "1m",
+			fn: func(ctx context.Context, attempt int) error {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return nil
+			},
+			attempts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := newTestTransaction()
+			defer tx.CancelFunc()
+			ctx := tx.Context
+
+			err := tx.RunWithRetries("test", tt.retries, "1ms", tt.timeout, func(attemptCtx context.Context, attempt int) error {
+				if attemptCtx == ctx && (tt.retries > 0 || tt.timeout != "") {
+					t.Errorf("attempt %d runs with the context of the transaction", attempt)
+				}
+				return tt.fn(attemptCtx, attempt)
+			})
+
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if len(tx.Attempts) != tt.attempts {
+				t.Fatalf("expected %d recorded attempts, got %d", tt.attempts, len(tx.Attempts))
+			}
+			for i, attempt := range tx.Attempts {
+				if attempt.Attempt != i+1 || attempt.TimedOut != tt.timedOut {
+					t.Errorf("unexpected attempt record: %+v", attempt)
+				}
+			}
+
+			// The context of the transaction is left untouched
+			if tx.Context != ctx || ctx.Err() != nil {
+				t.Errorf("transaction context has been changed")
+			}
+		})
+	}
+}
+
+func TestRunWithRetriesInvalidPolicy(t *testing.T) {
+	tx := newTestTransaction()
+	defer tx.CancelFunc()
+
+	for _, policy := range [][]string{{"soon", ""}, {"", "forever"}} {
+		err := tx.RunWithRetries("test", 1, policy[0], policy[1], func(ctx context.Context, attempt int) error {
+			t.Fatalf("fn must not run with an invalid policy")
+			return nil
+		})
+		if err == nil || !strings.Contains(err.Error(), "invalid duration") {
+			t.Errorf("expected invalid duration error for %v, got %v", policy, err)
+		}
+	}
+}
+
+func TestDetachedSubTransaction(t *testing.T) {
+	defer func(dir string) { polycrateRuntimeDir = dir }(polycrateRuntimeDir)
+	polycrateRuntimeDir = t.TempDir()
+
+	parent := newTestTransaction()
+	parent.CancelFunc()
+
+	tx := polycrate.DetachedSubTransaction(parent, 50*time.Millisecond)
+	defer polycrate.UnregisterTransaction(tx)
+
+	if tx.Parent != parent.TXID {
+		t.Errorf("expected parent %s, got %s", parent.TXID, tx.Parent)
+	}
+	// It isn't cancelled with its parent, only after the timeout
+	if err := tx.Context.Err(); err != nil {
+		t.Fatalf("expected an active context, got %s", err)
+	}
+	select {
+	case <-tx.Context.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("expected the context to time out")
+	}
+	if !goErrors.Is(tx.Context.Err(), context.DeadlineExceeded) {
+		t.Errorf("expected a deadline error, got %s", tx.Context.Err())
+	}
+	tx.CancelFunc()
+}
@@ -16,6 +16,7 @@ limitations under the License.
 package cmd
 
 import (
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
@@ -28,6 +29,10 @@ To run a Workflow, use this command with 1 argument - the Workflow name.
 To run an Action, use this command with 2 arguments - the Block name and the Action name.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) == 1 {
+			if len(actionInputs) > 0 {
+				log.Fatal("--input can only be used when running an action")
+			}
+
 			// Run a Worlflow
 			runWorkflowCmd.Run(cmd, args)
 		} else if len(args) == 2 {
@@ -45,5 +50,7 @@ To run an Action, use this command with 2 arguments - the Block name and the Act
 }
 
 func init() {
+	addActionRunFlags(runCmd.Flags())
+
 	rootCmd.AddCommand(runCmd)
 }
@@ -0,0 +1,302 @@
+/*
+Copyright © 2021 Fabian Peter <fp@ayedo.de>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	goErrors "errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+)
+
+var scheduleCmd = &cobra.Command{
+	Use:   "schedule",
+	Short: "Run scheduled Workflows and Actions",
+	Long: `Run the Workflows and Actions of the workspace that have a 'schedule' (cron expression, e.g. '0 3 * * *' or '@daily') until interrupted.
+Each run gets its own transaction and loads the workspace from disk, so changes to the workspace are picked up. Changes to the schedules themselves require a restart.
+A run is skipped and recorded as missed in the workspace logs if the previous run is still running or if it couldn't be started on time.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		_w := cmd.Flags().Lookup("workspace").Value.String()
+
+		tx := polycrate.Transaction().SetCommand(cmd)
+		defer tx.Stop()
+
+		workspace, err := polycrate.LoadWorkspace(tx, _w, true)
+		if err != nil {
+			tx.Log.Fatal(err)
+		}
+
+		err = workspace.RunScheduler(tx)
+		if err != nil {
+			tx.Log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(scheduleCmd)
+}
+
+// Schedule status values recorded in the labels of the events of scheduled runs
+const (
+	ScheduleStatusSucceeded string = "succeeded"
+	ScheduleStatusFailed    string = "failed"
+	ScheduleStatusMissed    string = "missed"
+	ScheduleStatusSkipped   string = "skipped"
+)
+
+// Loading the workspace from disk may pull blocks into it,
+// so runs of different jobs don't load their copy of the workspace at the same time
+var scheduleLoadLock sync.Mutex
+
+// A workflow or action that runs on a cron schedule
+type ScheduledJob struct {
+	Name     string
+	Schedule string
+	Workflow string
+	Block    string
+	Action   string
+	schedule cron.Schedule
+	// Prevents overlapping runs of the job
+	running   atomic.Bool
+	workspace *Workspace
+}
+
+// Returns all workflows and actions of the workspace that have a schedule
+// Actions of template blocks are never scheduled
+func (w *Workspace) GetScheduledJobs() ([]*ScheduledJob, error) {
+	jobs := []*ScheduledJob{}
+
+	for _, workflow := range w.Workflows {
+		if workflow.Schedule == "" {
+			continue
+		}
+		jobs = append(jobs, &ScheduledJob{
+			Name:     fmt.Sprintf("workflow %s", workflow.Name),
+			Schedule: workflow.Schedule,
+			Workflow: workflow.Name,
+		})
+	}
+
+	for _, block := range w.Blocks {
+		if block.Template {
+			continue
+		}
+		for _, action := range block.Actions {
+			if action.Schedule == "" {
+				continue
+			}
+			jobs = append(jobs, &ScheduledJob{
+				Name:     fmt.Sprintf("action %s:%s", block.Name, action.Name),
+				Schedule: action.Schedule,
+				Block:    block.Name,
+				Action:   action.Name,
+			})
+		}
+	}
+
+	for _, job := range jobs {
+		schedule, err := cron.ParseStandard(job.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule of %s: %w", job.Name, err)
+		}
+		job.schedule = schedule
+		job.workspace = w
+	}
+
+	return jobs, nil
+}
+
+// Runs all scheduled jobs of the workspace until the process receives SIGINT or SIGTERM
+// Runs that are still in progress are cancelled and waited for
+func (w *Workspace) RunScheduler(tx *PolycrateTransaction) error {
+	jobs, err := w.GetScheduledJobs()
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		return fmt.Errorf("no workflows or actions with a schedule found in workspace %s", w.Name)
+	}
+
+	// Cancelling the transaction cancels the sub-transactions of all runs
+	ctx, stop := signal.NotifyContext(tx.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		tx.CancelFunc()
+	}()
+
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		tx.Log.Infof("Scheduling %s (%s). Next run at %s", job.Name, job.Schedule, job.schedule.Next(time.Now()).Format(time.RFC3339))
+
+		wg.Add(1)
+		go func(job *ScheduledJob) {
+			defer wg.Done()
+			job.loop(tx)
+		}(job)
+	}
+	wg.Wait()
+
+	tx.Log.Infof("Scheduler stopped")
+	return nil
+}
+
+// Waits for the due times of the job and starts a run for each of them
+// Due times that can't be run on time or while the previous run is still running are recorded as missed
+func (j *ScheduledJob) loop(tx *PolycrateTransaction) {
+	var runs sync.WaitGroup
+	defer runs.Wait()
+
+	next := j.schedule.Next(time.Now())
+	for {
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-tx.Context.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		now := time.Now()
+
+		// Only the latest due time is considered if several have passed
+		// (e.g. because the machine has been suspended)
+		due := next
+		missed := 0
+		for latest := j.schedule.Next(due); !latest.After(now); latest = j.schedule.Next(latest) {
+			missed++
+			due = latest
+		}
+		if missed > 0 {
+			j.recordMiss(tx, next, fmt.Sprintf("%d run(s) between %s and %s have not been started on time", missed, next.Format(time.RFC3339), due.Format(time.RFC3339)))
+		}
+		next = j.schedule.Next(now)
+
+		if late := now.Sub(due); late > ScheduleMissTolerance {
+			j.recordMiss(tx, due, fmt.Sprintf("the run would have started %s late", late.Round(time.Second)))
+			continue
+		}
+
+		if !j.running.CompareAndSwap(false, true) {
+			j.recordMiss(tx, due, "the previous run is still running")
+			continue
+		}
+
+		runs.Add(1)
+		go func(due time.Time) {
+			defer runs.Done()
+			defer j.running.Store(false)
+
+			j.run(tx, due)
+		}(due)
+	}
+}
+
+// Runs the job in a sub-transaction against a freshly loaded copy of the workspace
+func (j *ScheduledJob) run(tx *PolycrateTransaction, due time.Time) {
+	jtx := polycrate.SubTransaction(tx)
+	jtx.Log.SetField("schedule", j.Name)
+	jtx.SetLabel("polycrate.schedule", j.Name)
+	jtx.SetLabel("polycrate.schedule.due", due.Format(time.RFC3339))
+
+	defer func() {
+		transactionLock.Lock()
+		defer transactionLock.Unlock()
+		jtx.Stop()
+	}()
+
+	jtx.Log.Infof("Starting scheduled run due at %s", due.Format(time.RFC3339))
+
+	err := j.runInWorkspace(jtx)
+	if goErrors.Is(err, ErrConditionNotMet) {
+		jtx.Log.Infof("Scheduled run skipped. Next run at %s", j.schedule.Next(time.Now()).Format(time.RFC3339))
+		jtx.SetLabel("polycrate.schedule.status", ScheduleStatusSkipped)
+		return
+	}
+	if err != nil {
+		jtx.Log.Errorf("Scheduled run failed: %s", err)
+		jtx.SetLabel("polycrate.schedule.status", ScheduleStatusFailed)
+		return
+	}
+
+	jtx.Log.Infof("Scheduled run succeeded. Next run at %s", j.schedule.Next(time.Now()).Format(time.RFC3339))
+	jtx.SetLabel("polycrate.schedule.status", ScheduleStatusSucceeded)
+}
+
+func (j *ScheduledJob) runInWorkspace(tx *PolycrateTransaction) error {
+	scheduleLoadLock.Lock()
+	workspace, err := j.workspace.LoadClone(tx)
+	scheduleLoadLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Make sure the run shows up in the workspace logs
+	// Actions replace this with their full snapshot when they start
+	tx.Snapshot = j.getSnapshot(workspace)
+
+	if j.Workflow != "" {
+		return workspace.RunWorkflow(tx, j.Workflow, "")
+	}
+	return workspace.RunAction(tx, j.Block, j.Action)
+}
+
+// Saves an event for a run that has not been started to the workspace logs
+func (j *ScheduledJob) recordMiss(tx *PolycrateTransaction, due time.Time, reason string) {
+	tx.Log.Warnf("Missed scheduled run of %s due at %s: %s", j.Name, due.Format(time.RFC3339), reason)
+
+	mtx := polycrate.SubTransaction(tx)
+	mtx.SetLabel("polycrate.schedule", j.Name)
+	mtx.SetLabel("polycrate.schedule.due", due.Format(time.RFC3339))
+	mtx.SetLabel("polycrate.schedule.status", ScheduleStatusMissed)
+	mtx.SetOutput(reason)
+	mtx.Snapshot = j.getSnapshot(j.workspace)
+
+	transactionLock.Lock()
+	defer transactionLock.Unlock()
+	mtx.Stop()
+}
+
+// Returns a snapshot of the workspace that references the workflow or block and action of the job
+func (j *ScheduledJob) getSnapshot(workspace *Workspace) WorkspaceSnapshot {
+	snapshot := WorkspaceSnapshot{
+		Workspace: workspace,
+	}
+
+	if j.Workflow != "" {
+		if workflow, err := workspace.GetWorkflow(j.Workflow); err == nil {
+			snapshot.Workflow = workflow
+		}
+		return snapshot
+	}
+
+	if block, err := workspace.GetBlock(j.Block); err == nil {
+		snapshot.Block = block
+		if action, err := block.GetAction(j.Action); err == nil {
+			snapshot.Action = action
+		}
+	}
+	return snapshot
+}
@@ -0,0 +1,278 @@
+/*
+Copyright © 2021 Fabian Peter <fp@ayedo.de>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// What happens if a pulled block isn't signed by a trusted key
+const (
+	TrustPolicyEnforce string = "enforce"
+	TrustPolicyWarn    string = "warn"
+	TrustPolicyOff     string = "off"
+)
+
+// Strictness of the trust modes
+var trustPolicyLevels = map[string]int{
+	TrustPolicyOff:     0,
+	TrustPolicyWarn:    1,
+	TrustPolicyEnforce: 2,
+}
+
+// Labels of the signature artifact of a block image
+const (
+	BlocksSignatureLabel    string = "polycrate.blocks.signature"
+	BlocksSignatureKeyLabel string = "polycrate.blocks.signature.key"
+	// The payload that has been signed (JSON)
+	BlocksSignaturePayloadLabel string = "polycrate.blocks.signature.payload"
+)
+
+// The signature artifact of a block image is pushed to the same repository with this tag
+// e.g. sha256-<hex>.sig for the image with the digest sha256:<hex>
+const BlocksSignatureTagSuffix string = ".sig"
+
+// Path of the private key blocks are signed with; defaults to the key in the config directory
+var signingKeyPath string
+
+func getSigningKeyPath() string {
+	if signingKeyPath != "" {
+		return signingKeyPath
+	}
+	return filepath.Join(polycrateConfigDir, BlocksSigningKey)
+}
+
+// Keys that are trusted to sign the blocks pulled into a workspace
+// Keys are public ed25519 keys in authorized_keys format (ssh-ed25519 AAAA... comment)
+type TrustPolicy struct {
+	// `enforce`, `warn` or `off` (default)
+	Mode string   `yaml:"mode,omitempty" mapstructure:"mode,omitempty" json:"mode,omitempty" validate:"omitempty,oneof=enforce warn off"`
+	Keys []string `yaml:"keys,omitempty" mapstructure:"keys,omitempty" json:"keys,omitempty"`
+}
+
+// Returns the trust policy of the workspace
+// Keys trusted globally are trusted in every workspace. A workspace can only tighten the global mode
+// and can't add trusted keys if the global mode is enforce, so it can't weaken the global policy
+func (w *Workspace) getTrustPolicy() TrustPolicy {
+	policy := TrustPolicy{
+		Mode: polycrate.Config.Trust.Mode,
+		Keys: append([]string{}, polycrate.Config.Trust.Keys...),
+	}
+	if policy.Mode == "" {
+		policy.Mode = TrustPolicyOff
+	}
+
+	if policy.Mode != TrustPolicyEnforce {
+		policy.Keys = append(policy.Keys, w.Config.Trust.Keys...)
+	}
+	if trustPolicyLevels[w.Config.Trust.Mode] > trustPolicyLevels[policy.Mode] {
+		policy.Mode = w.Config.Trust.Mode
+	}
+	return policy
+}
+
+// Loads the private signing key; the key pair is created if it doesn't exist
+func loadSigningKey(path string) (ssh.Signer, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := createSigningKey(path); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key '%s': %s", path, err)
+	}
+	if signer.PublicKey().Type() != ssh.KeyAlgoED25519 {
+		return nil, fmt.Errorf("signing key '%s' is not an ed25519 key", path)
+	}
+	return signer, nil
+}
+
+// Creates an ed25519 key pair in OpenSSH format (path and path.pub)
+func createSigningKey(path string) error {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	privPEM, err := ssh.MarshalPrivateKey(priv, "polycrate")
+	if err != nil {
+		return err
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, pem.EncodeToMemory(privPEM), 0600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".pub", ssh.MarshalAuthorizedKey(sshPub), 0644); err != nil {
+		return err
+	}
+
+	log.WithField("path", path).Warnf("Created signing key %s. Add the public key in %s.pub to the trust policy to trust blocks signed with it", ssh.FingerprintSHA256(sshPub), path)
+	return nil
+}
+
+// What is signed for a block image
+// Like cosign's payload, it contains the repository, so a signature can't be copied to another repository
+type SignaturePayload struct {
+	// The repository the image has been pushed to (registry/name)
+	Reference string `yaml:"docker-reference" mapstructure:"docker-reference" json:"docker-reference"`
+	Digest    string `yaml:"docker-manifest-digest" mapstructure:"docker-manifest-digest" json:"docker-manifest-digest"`
+}
+
+// Returns the reference of the signature artifact of the image with the given digest
+func getOCISignatureReference(repository name.Repository, digest string) (name.Tag, error) {
+	return name.NewTag(strings.Join([]string{repository.String(), strings.Replace(digest, ":", "-", 1) + BlocksSignatureTagSuffix}, ":"))
+}
+
+// Signs the digest of the image and pushes the signature artifact next to it
+func SignOCIImage(ctx context.Context, repository name.Repository, digest string, signer ssh.Signer) error {
+	payload, err := json.Marshal(SignaturePayload{
+		Reference: repository.Name(),
+		Digest:    digest,
+	})
+	if err != nil {
+		return err
+	}
+
+	signature, err := signer.Sign(rand.Reader, payload)
+	if err != nil {
+		return err
+	}
+
+	tag, err := getOCISignatureReference(repository, digest)
+	if err != nil {
+		return err
+	}
+
+	log := log.WithField("image", tag.String())
+	log = log.WithField("key", ssh.FingerprintSHA256(signer.PublicKey()))
+
+	img, err := mutate.Config(empty.Image, v1.Config{
+		Labels: map[string]string{
+			BlocksSignatureLabel:        base64.StdEncoding.EncodeToString(ssh.Marshal(signature)),
+			BlocksSignatureKeyLabel:     strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))),
+			BlocksSignaturePayloadLabel: string(payload),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Pushing signature")
+	return crane.Push(img, tag.String())
+}
+
+// Verifies the signature artifact of the image with the given digest
+// Returns the fingerprint of the key the image has been signed with
+// Fails if the image isn't signed or not signed by one of the trusted keys
+func VerifyOCIImage(ctx context.Context, repository name.Repository, digest string, trustedKeys []string) (string, error) {
+	trusted := map[string]ssh.PublicKey{}
+	for _, key := range trustedKeys {
+		publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+		if err != nil {
+			return "", fmt.Errorf("failed to parse trusted key '%s': %s", key, err)
+		}
+		trusted[ssh.FingerprintSHA256(publicKey)] = publicKey
+	}
+
+	tag, err := getOCISignatureReference(repository, digest)
+	if err != nil {
+		return "", err
+	}
+
+	if !OCIImageExists(tag.String()) {
+		return "", fmt.Errorf("image %s@%s is not signed", repository.String(), digest)
+	}
+
+	img, err := PullOCIImage(ctx, tag.String())
+	if err != nil {
+		return "", err
+	}
+
+	config, err := img.ConfigFile()
+	if err != nil {
+		return "", err
+	}
+	labels := config.Config.Labels
+
+	// The signature must have been created for this image in this repository
+	payload := labels[BlocksSignaturePayloadLabel]
+	if payload == "" {
+		return "", fmt.Errorf("signature of image %s@%s has no payload", repository.String(), digest)
+	}
+	signed := SignaturePayload{}
+	if err := json.Unmarshal([]byte(payload), &signed); err != nil {
+		return "", fmt.Errorf("failed to decode signature payload of image %s@%s: %s", repository.String(), digest, err)
+	}
+	if signed.Reference != repository.Name() {
+		return "", fmt.Errorf("signature of image %s@%s has been created for repository '%s'", repository.String(), digest, signed.Reference)
+	}
+	if signed.Digest != digest {
+		return "", fmt.Errorf("signature of image %s@%s has been created for digest '%s'", repository.String(), digest, signed.Digest)
+	}
+
+	signingKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(labels[BlocksSignatureKeyLabel]))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse key of signature of image %s@%s: %s", repository.String(), digest, err)
+	}
+	fingerprint := ssh.FingerprintSHA256(signingKey)
+
+	publicKey, ok := trusted[fingerprint]
+	if !ok {
+		return fingerprint, fmt.Errorf("image %s@%s is signed with untrusted key %s", repository.String(), digest, fingerprint)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(labels[BlocksSignatureLabel])
+	if err != nil {
+		return fingerprint, fmt.Errorf("failed to decode signature of image %s@%s: %s", repository.String(), digest, err)
+	}
+	signature := &ssh.Signature{}
+	if err := ssh.Unmarshal(data, signature); err != nil {
+		return fingerprint, fmt.Errorf("failed to decode signature of image %s@%s: %s", repository.String(), digest, err)
+	}
+
+	if err := publicKey.Verify([]byte(payload), signature); err != nil {
+		return fingerprint, fmt.Errorf("invalid signature of image %s@%s: %s", repository.String(), digest, err)
+	}
+	return fingerprint, nil
+}
@@ -0,0 +1,279 @@
+/*
+Copyright © 2021 Fabian Peter <fp@ayedo.de>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"golang.org/x/crypto/ssh"
+)
+
+// Returns a new signing key and its public key in authorized_keys format
+func newTestSigner(t *testing.T) (ssh.Signer, string) {
+	path := filepath.Join(t.TempDir(), "signing.key")
+	signer, err := loadSigningKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := os.ReadFile(path + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, string(public)
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signing.key")
+
+	created, err := loadSigningKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.PublicKey().Type() != ssh.KeyAlgoED25519 {
+		t.Errorf("expected an ed25519 key, got %s", created.PublicKey().Type())
+	}
+
+	// The existing key is loaded again
+	loaded, err := loadSigningKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ssh.FingerprintSHA256(loaded.PublicKey()) != ssh.FingerprintSHA256(created.PublicKey()) {
+		t.Errorf("expected the key to be loaded, got a different key")
+	}
+
+	if err := os.WriteFile(path, []byte("invalid"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadSigningKey(path); err == nil {
+		t.Errorf("expected an error for an invalid key")
+	}
+}
+
+func TestSignAndVerifyOCIImage(t *testing.T) {
+	ctx := context.Background()
+	host := newTestRegistry(t)
+
+	repository, err := name.NewRepository(host + "/blocks/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRepository, err := name.NewRepository(host + "/blocks/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := pushTestImage(t, repository.String(), "1.0.0")
+	otherDigest := pushTestImage(t, repository.String(), "2.0.0")
+	pushTestImage(t, otherRepository.String(), "1.0.0")
+
+	signer, public := newTestSigner(t)
+	_, otherPublic := newTestSigner(t)
+	fingerprint := ssh.FingerprintSHA256(signer.PublicKey())
+
+	if err := SignOCIImage(ctx, repository, digest, signer); err != nil {
+		t.Fatal(err)
+	}
+
+	signature, err := getOCISignatureReference(repository, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Copies the signature of the image to another image or repository
+	copySignature := func(t *testing.T, repository name.Repository, digest string) {
+		tag, err := getOCISignatureReference(repository, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := crane.Copy(signature.String(), tag.String()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		prepare     func(t *testing.T)
+		repository  name.Repository
+		digest      string
+		trustedKeys []string
+		fingerprint string
+		err         string
+	}{
+		{
+			name:        "trusted key",
+			repository:  repository,
+			digest:      digest,
+			trustedKeys: []string{otherPublic, public},
+			fingerprint: fingerprint,
+		},
+		{
+			name:        "untrusted key",
+			repository:  repository,
+			digest:      digest,
+			trustedKeys: []string{otherPublic},
+			fingerprint: fingerprint,
+			err:         "is signed with untrusted key " + fingerprint,
+		},
+		{
+			name:        "no trusted keys",
+			repository:  repository,
+			digest:      digest,
+			fingerprint: fingerprint,
+			err:         "is signed with untrusted key",
+		},
+		{
+			name:        "invalid trusted key",
+			repository:  repository,
+			digest:      digest,
+			trustedKeys: []string{"ssh-ed25519 invalid"},
+			err:         "failed to parse trusted key",
+		},
+		{
+			name:        "unsigned image",
+			repository:  repository,
+			digest:      otherDigest,
+			trustedKeys: []string{public},
+			err:         "is not signed",
+		},
+		{
+			name:        "signature copied to another digest",
+			prepare:     func(t *testing.T) { copySignature(t, repository, otherDigest) },
+			repository:  repository,
+			digest:      otherDigest,
+			trustedKeys: []string{public},
+			err:         "has been created for digest '" + digest + "'",
+		},
+		{
+			name:        "signature copied to another repository",
+			prepare:     func(t *testing.T) { copySignature(t, otherRepository, digest) },
+			repository:  otherRepository,
+			digest:      digest,
+			trustedKeys: []string{public},
+			err:         "has been created for repository '" + repository.Name() + "'",
+		},
+		{
+			name: "tampered payload",
+			prepare: func(t *testing.T) {
+				img, err := crane.Pull(signature.String())
+				if err != nil {
+					t.Fatal(err)
+				}
+				config, err := img.ConfigFile()
+				if err != nil {
+					t.Fatal(err)
+				}
+				labels := config.Config.Labels
+				labels[BlocksSignaturePayloadLabel] = strings.Replace(labels[BlocksSignaturePayloadLabel], "{", `{"extra":"x",`, 1)
+				img, err = mutate.Config(img, v1.Config{Labels: labels})
+				if err != nil {
+					t.Fatal(err)
+				}
+				// The payload still names the image, only the signature doesn't match it anymore
+				// This replaces the signature, so it's the last case
+				if err := crane.Push(img, signature.String()); err != nil {
+					t.Fatal(err)
+				}
+			},
+			repository:  repository,
+			digest:      digest,
+			trustedKeys: []string{public},
+			fingerprint: fingerprint,
+			err:         "invalid signature",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.prepare != nil {
+				tt.prepare(t)
+			}
+
+			fingerprint, err := VerifyOCIImage(ctx, tt.repository, tt.digest, tt.trustedKeys)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if fingerprint != tt.fingerprint {
+				t.Errorf("expected fingerprint %q, got %q", tt.fingerprint, fingerprint)
+			}
+		})
+	}
+}
+
+func TestGetTrustPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		global    TrustPolicy
+		workspace TrustPolicy
+		expected  TrustPolicy
+	}{
+		{
+			name:     "defaults to off",
+			expected: TrustPolicy{Mode: TrustPolicyOff, Keys: []string{}},
+		},
+		{
+			name:      "workspace tightens the global mode",
+			global:    TrustPolicy{Mode: TrustPolicyWarn, Keys: []string{"global"}},
+			workspace: TrustPolicy{Mode: TrustPolicyEnforce, Keys: []string{"workspace"}},
+			expected:  TrustPolicy{Mode: TrustPolicyEnforce, Keys: []string{"global", "workspace"}},
+		},
+		{
+			name:      "workspace can't loosen the global mode",
+			global:    TrustPolicy{Mode: TrustPolicyWarn},
+			workspace: TrustPolicy{Mode: TrustPolicyOff},
+			expected:  TrustPolicy{Mode: TrustPolicyWarn, Keys: []string{}},
+		},
+		{
+			name:      "workspace keys are ignored if the global mode is enforce",
+			global:    TrustPolicy{Mode: TrustPolicyEnforce, Keys: []string{"global"}},
+			workspace: TrustPolicy{Mode: TrustPolicyWarn, Keys: []string{"workspace"}},
+			expected:  TrustPolicy{Mode: TrustPolicyEnforce, Keys: []string{"global"}},
+		},
+		{
+			name:      "unknown workspace mode is ignored",
+			global:    TrustPolicy{Mode: TrustPolicyWarn},
+			workspace: TrustPolicy{Mode: "strict"},
+			expected:  TrustPolicy{Mode: TrustPolicyWarn, Keys: []string{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(trust TrustPolicy) { polycrate.Config.Trust = trust }(polycrate.Config.Trust)
+			polycrate.Config.Trust = tt.global
+
+			w := &Workspace{}
+			w.Config.Trust = tt.workspace
+
+			if policy := w.getTrustPolicy(); !reflect.DeepEqual(policy, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, policy)
+			}
+		})
+	}
+}
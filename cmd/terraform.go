@@ -0,0 +1,189 @@
+/*
+Copyright © 2021 Fabian Peter <fp@ayedo.de>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Terraform actions
+const (
+	ActionTerraformInit    string = "init"
+	ActionTerraformPlan    string = "plan"
+	ActionTerraformApply   string = "apply"
+	ActionTerraformDestroy string = "destroy"
+)
+
+// Files of the terraform action in the artifacts of the block
+const (
+	TerraformStateFile string = "terraform.tfstate"
+	TerraformPlanFile  string = "terraform.tfplan"
+	TerraformDataDir   string = ".terraform"
+)
+
+// Returns the stages the terraform action runs through
+// apply and destroy create a plan first that needs to be confirmed before it's applied
+func (a *Action) getTerraformStages() []string {
+	switch a.Terraform {
+	case ActionTerraformApply, ActionTerraformDestroy:
+		return []string{ActionTerraformPlan, ActionTerraformApply}
+	default:
+		return []string{a.Terraform}
+	}
+}
+
+// Returns the script that runs the given stages of the terraform action in the workdir of the block
+// State, plan and providers are kept in the artifacts of the block. OpenTofu is used if terraform isn't installed
+func (a *Action) getTerraformScript(stages ...string) []string {
+	artifactsPath := a.block.Artifacts.Path
+	state := shellQuote(filepath.Join(artifactsPath, TerraformStateFile))
+	plan := shellQuote(filepath.Join(artifactsPath, TerraformPlanFile))
+
+	script := []string{
+		"#!/bin/bash",
+		"set -euo pipefail",
+		"trap 'exit 1' SIGINT",
+		"trap 'exit 1' SIGTERM",
+		"TF=terraform",
+		"if ! command -v terraform > /dev/null 2>&1 && command -v tofu > /dev/null 2>&1; then TF=tofu; fi",
+		"export TF_IN_AUTOMATION=1",
+		"export TF_DATA_DIR=" + shellQuote(filepath.Join(artifactsPath, TerraformDataDir)),
+		"mkdir -p " + shellQuote(artifactsPath),
+		"$TF init -input=false",
+	}
+
+	for _, stage := range stages {
+		switch stage {
+		case ActionTerraformPlan:
+			planCmd := fmt.Sprintf("$TF plan -input=false -state=%s -var-file=\"$POLYCRATE_TERRAFORM_VARS\" -out=%s", state, plan)
+			if a.Terraform == ActionTerraformDestroy {
+				planCmd += " -destroy"
+			}
+			script = append(script, planCmd)
+		case ActionTerraformApply:
+			script = append(script, fmt.Sprintf("$TF apply -input=false -state=%s %s", state, plan))
+			// The plan has been applied at this point, so failing to read the outputs doesn't fail the action
+			if a.Terraform == ActionTerraformApply {
+				script = append(script, fmt.Sprintf("$TF output -state=%s -json | jq -r 'to_entries[] | \"\\(.key)=\\(.value.value | tostring)\"' >> \"$POLYCRATE_OUTPUT\" || echo \"Failed to read the outputs of terraform\" >&2", state))
+			}
+			script = append(script, "rm -f "+plan)
+		}
+	}
+	return script
+}
+
+// Saves the config of the block as tfvars JSON
+func (a *Action) saveTerraformVars(tx *PolycrateTransaction) error {
+	block := a.block
+	workspace := block.workspace
+
+	// Terraform needs string keys
+	vars := map[string]interface{}{}
+	data, err := yaml.Marshal(block.Config)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(data, &vars); err != nil {
+		return fmt.Errorf("failed to decode config of block '%s': %s", block.Name, err)
+	}
+
+	varsJSON, err := json.Marshal(vars)
+	if err != nil {
+		return err
+	}
+
+	varsFilename := strings.Join([]string{slugify([]string{tx.TXID.String(), "terraform", "vars"}), "tfvars", "json"}, ".")
+	f, err := polycrate.getTempFile(tx.Context, varsFilename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(varsJSON); err != nil {
+		return err
+	}
+
+	workspace.registerMount(f.Name(), f.Name())
+	workspace.registerEnvVar("POLYCRATE_TERRAFORM_VARS", f.Name())
+	return nil
+}
+
+// Runs the terraform action of the block
+// Without --force, the plan of apply and destroy must be confirmed before it's applied
+// In check mode, only the plan is created
+func (a *Action) runTerraform(tx *PolycrateTransaction) error {
+	block := a.block
+	workspace := block.workspace
+
+	if err := a.saveTerraformVars(tx); err != nil {
+		return err
+	}
+
+	// Each run is a batch of stages; a confirmation is needed between plan and apply
+	stages := a.getTerraformStages()
+	runs := [][]string{stages}
+	if len(stages) > 1 && (checkMode || !force) {
+		runs = [][]string{stages[:1], stages[1:]}
+	}
+
+	output := []string{}
+	for i, run := range runs {
+		if i > 0 {
+			if checkMode {
+				tx.Log.Infof("Not applying plan in check mode")
+				break
+			}
+
+			prompt := Prompt{
+				Message: fmt.Sprintf("Apply the terraform plan (%s) of block '%s'?", a.Terraform, block.Name),
+			}
+			if !prompt.Validate() {
+				return fmt.Errorf("not applying plan. user confirmation declined")
+			}
+		}
+
+		if err := a.saveScript(tx, a.getTerraformScript(run...)); err != nil {
+			return err
+		}
+		workspace.registerMount(a.executionScriptPath, a.executionScriptPath)
+		workspace.registerMount(workspace.getOutputPath(), workspace.getOutputPath())
+
+		// A saved plan can't be applied again once applying it has been started,
+		// so a run that doesn't create its own plan isn't retried
+		retries := a.Retries
+		if run[0] != ActionTerraformPlan {
+			retries = 0
+		}
+
+		err := tx.RunWithRetries(fmt.Sprintf("action %s:%s", block.Name, a.Name), retries, a.RetryDelay, a.Timeout, func(ctx context.Context, attempt int) error {
+			return a.execute(ctx, tx)
+		})
+
+		// Keep the output of the plan in the log
+		output = append(output, tx.Output)
+		tx.SetOutput(strings.Join(output, "\n"))
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -33,6 +33,7 @@ import (
 
 	validator "github.com/go-playground/validator/v10"
 	"github.com/manifoldco/promptui"
+	"github.com/robfig/cron/v3"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
 )
@@ -751,6 +752,18 @@ func validateBlockName(fl validator.FieldLevel) bool {
 	return ValidateBlockName(name)
 }
 
+func validateDuration(fl validator.FieldLevel) bool {
+	_, err := time.ParseDuration(fl.Field().String())
+
+	return err == nil
+}
+
+func validateSchedule(fl validator.FieldLevel) bool {
+	_, err := cron.ParseStandard(fl.Field().String())
+
+	return err == nil
+}
+
 // func discoverWorkspaces() error {
 // 	workspacesDir := polycrateWorkspaceDir
 
@@ -2,8 +2,10 @@ package cmd
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	jsoniter "github.com/json-iterator/go"
@@ -47,15 +49,53 @@ const WorkspaceConfigSshPrivateKey string = "id_rsa"
 // default workspace config file
 const WorkspaceConfigFile string = "workspace.poly"
 
+// lockfile with the resolved versions of the workspace dependencies
+const WorkspaceLockfileName string = "workspace.lock"
+
 // default workspace logs path
 const WorkspaceConfigLogsRoot string = ".logs"
 
+// directory inside the workspace logs that holds the progress of workflow runs
+const WorkspaceWorkflowRunsDir string = "workflows"
+
+// directory inside the runtime directory that holds the locks of running actions
+const WorkspaceLocksDir string = "locks"
+
+// directory inside the workspace logs that holds the cache keys of the last successful run of each action
+const WorkspaceCacheDir string = "cache"
+
 // default block config file
 const BlocksConfigFile string = "block.poly"
 
+// what happens if a pulled block has been modified
+const (
+	WorkspaceVerifyBlocksWarn string = "warn"
+	WorkspaceVerifyBlocksFail string = "fail"
+	WorkspaceVerifyBlocksOff  string = "off"
+)
+
+// default private key in the config directory that blocks are signed with
+const BlocksSigningKey string = "blocks_signing_key"
+
+// suffix of the metadata file next to the directory of a pulled block
+const BlocksMetadataSuffix string = ".meta.yml"
+
 // default block changelog file
 const BlocksChangelogFile string = "CHANGELOG.poly"
 
+// default number of workflow steps that may run at the same time
+const WorkflowDefaultParallelism int = 4
+
+// maximum duration of the on_failure and finally steps of a workflow
+// They run even if the workflow has been cancelled, so they can't be interrupted by that
+const WorkflowHookTimeout time.Duration = 10 * time.Minute
+
+// maximum delay between two attempts of an action or step
+const RetryMaxDelay time.Duration = 10 * time.Minute
+
+// scheduled runs that start later than this (e.g. after the machine has been suspended) are recorded as missed
+const ScheduleMissTolerance time.Duration = time.Minute
+
 // default env prefix
 const EnvPrefix string = "polycrate"
 
@@ -135,6 +175,10 @@ var logrusLevel log.Level
 // Global variable for the current working directory
 var cwd, _ = os.Getwd()
 
+// Global variable to limit the number of workflow steps running at the same time
+// Can be overriden with the --parallelism flag
+var workflowParallelism int
+
 // Global variable to decide the output format (json/yaml)
 // Can be overriden with the --output-format flag
 var outputFormat string
@@ -193,6 +237,10 @@ var KubeconfigPath string = filepath.Join(home, ".kube", "config")
 // Errors
 var ErrDependencyNotResolved = errors.New("block dependency not resolved")
 var ErrWorkspaceConfigNotFound = errors.New("workspace config not found")
+var ErrConditionNotMet = errors.New("condition not met")
+
+// Actions that can't be dry-run are skipped in check mode; they count as skipped like actions whose condition isn't met
+var ErrCheckModeNotSupported = fmt.Errorf("%w: the action can't run in check mode", ErrConditionNotMet)
 
 //var signals = make(chan os.Signal, 1)
 
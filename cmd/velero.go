@@ -38,7 +38,7 @@ var veleroCmd = &cobra.Command{
 
 		_cmd = append(_cmd, result)
 
-		err = workspace.RunContainer(tx, "polycrate-velero", workspace.LocalPath, _cmd)
+		err = workspace.RunContainer(tx.Context, tx, "polycrate-velero", workspace.LocalPath, _cmd, ContainerConfig{})
 		if err != nil {
 			log.Fatal(err)
 		}
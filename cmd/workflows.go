@@ -16,13 +16,22 @@ limitations under the License.
 package cmd
 
 import (
+	"bytes"
+	"context"
 	goErrors "errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
 )
 
 // installCmd represents the install command
@@ -61,14 +70,43 @@ type Step struct {
 	Description string            `yaml:"description,omitempty" mapstructure:"description,omitempty" json:"description,omitempty"`
 	Labels      map[string]string `yaml:"labels,omitempty" mapstructure:"labels,omitempty" json:"labels,omitempty"`
 	Alias       []string          `yaml:"alias,omitempty" mapstructure:"alias,omitempty" json:"alias,omitempty"`
-	Block       string            `yaml:"block" mapstructure:"block" json:"block" validate:"required"`
-	Action      string            `yaml:"action" mapstructure:"action" json:"action" validate:"required"`
+	Block       string            `yaml:"block" mapstructure:"block" json:"block" validate:"required_without_all=Matrix RunWorkflow"`
+	Action      string            `yaml:"action" mapstructure:"action" json:"action" validate:"required_without=RunWorkflow"`
 	Prompt      Prompt            `yaml:"prompt,omitempty" mapstructure:"prompt,omitempty" json:"prompt,omitempty"`
 	Workflow    string            `yaml:"workflow,omitempty" mapstructure:"workflow,omitempty" json:"workflow,omitempty"`
-	workflow    *Workflow
+	// Run another workflow of the workspace instead of an action
+	RunWorkflow string `yaml:"run_workflow,omitempty" mapstructure:"run_workflow,omitempty" json:"run_workflow,omitempty" validate:"excluded_with=Block Action Matrix"`
+	// Values for the inputs of the action; Go templates are rendered against the snapshot of the step
+	Inputs map[string]string `yaml:"inputs,omitempty" mapstructure:"inputs,omitempty" json:"inputs,omitempty" validate:"excluded_with=RunWorkflow"`
+	// Run the action for all blocks matching the matrix instead of a single block
+	Matrix *StepMatrix `yaml:"matrix,omitempty" mapstructure:"matrix,omitempty" json:"matrix,omitempty" validate:"excluded_with=Block"`
+	// Names of the steps that must have finished before this step can run
+	Needs        []string `yaml:"needs,omitempty" mapstructure:"needs,omitempty" json:"needs,omitempty"`
+	AllowFailure bool     `yaml:"allow_failure,omitempty" mapstructure:"allow_failure,omitempty" json:"allow_failure,omitempty"`
+	// Go template evaluated against the workspace snapshot; the step is skipped if it's false
+	When string `yaml:"when,omitempty" mapstructure:"when,omitempty" json:"when,omitempty"`
+	// Number of times the step is retried after it failed
+	// Retries, retry_delay and timeout of the step replace the ones of its action
+	Retries int `yaml:"retries,omitempty" mapstructure:"retries,omitempty" json:"retries,omitempty" validate:"gte=0"`
+	// Delay before the first retry (e.g. 10s); it doubles with every further retry
+	RetryDelay string `yaml:"retry_delay,omitempty" mapstructure:"retry_delay,omitempty" json:"retry_delay,omitempty" validate:"omitempty,duration"`
+	// Maximum duration of a single attempt (e.g. 30m)
+	Timeout         string `yaml:"timeout,omitempty" mapstructure:"timeout,omitempty" json:"timeout,omitempty" validate:"omitempty,duration"`
+	workflow        *Workflow
+	matrixResults   []StepResult
+	workflowResults []StepResult
 	//err         error
 }
 
+// Selects the blocks a matrix step runs its action for
+// Blocks must match all given criteria; template blocks are never selected
+type StepMatrix struct {
+	// Select blocks that have all of these labels
+	Labels map[string]string `yaml:"labels,omitempty" mapstructure:"labels,omitempty" json:"labels,omitempty"`
+	// Select blocks derived from this block
+	From string `yaml:"from,omitempty" mapstructure:"from,omitempty" json:"from,omitempty"`
+}
+
 type Workflow struct {
 	//Metadata    Metadata          `mapstructure:"metadata" json:"metadata" validate:"required"`
 	Name         string            `yaml:"name,omitempty" mapstructure:"name,omitempty" json:"name,omitempty" validate:"required,metadata_name"`
@@ -78,8 +116,133 @@ type Workflow struct {
 	Steps        []Step            `yaml:"steps,omitempty" mapstructure:"steps,omitempty" json:"steps,omitempty"`
 	Prompt       Prompt            `yaml:"prompt,omitempty" mapstructure:"prompt,omitempty" json:"prompt,omitempty"`
 	AllowFailure bool              `yaml:"allow_failure,omitempty" mapstructure:"allow_failure,omitempty" json:"allow_failure,omitempty"`
+	// Maximum number of steps running at the same time (default: WorkflowDefaultParallelism)
+	Parallelism int `yaml:"parallelism,omitempty" mapstructure:"parallelism,omitempty" json:"parallelism,omitempty"`
+	// Steps that run one after another if the workflow failed
+	OnFailure []Step `yaml:"on_failure,omitempty" mapstructure:"on_failure,omitempty" json:"on_failure,omitempty"`
+	// Steps that run one after another when the workflow ends, regardless of its outcome
+	Finally []Step `yaml:"finally,omitempty" mapstructure:"finally,omitempty" json:"finally,omitempty"`
+	// Cron expression (e.g. `0 3 * * *` or `@daily`); `polycrate schedule` runs the workflow accordingly
+	Schedule string `yaml:"schedule,omitempty" mapstructure:"schedule,omitempty" json:"schedule,omitempty" validate:"omitempty,schedule"`
 	//err         error
 	workspace *Workspace
+	// File the workflow has been loaded from (empty for workflows in the workspace config)
+	path string
+	// The current run of the workflow; it's copied with the workspace the steps run in
+	run *WorkflowRun
+}
+
+// The step that made a workflow fail; only the error is set if the workflow has been cancelled
+// Available to on_failure and finally steps as `.Failure` in the snapshot
+type WorkflowFailure struct {
+	Step     string `yaml:"step,omitempty" mapstructure:"step,omitempty" json:"step,omitempty"`
+	ExitCode int    `yaml:"exit_code,omitempty" mapstructure:"exit_code,omitempty" json:"exit_code,omitempty"`
+	Error    string `yaml:"error,omitempty" mapstructure:"error,omitempty" json:"error,omitempty"`
+}
+
+// Stopping a transaction (events, auto-commit) touches the workspace directory,
+// so transactions of parallel steps and scheduled runs are stopped one after another
+var transactionLock sync.Mutex
+
+// Step status values reported in the summary of a workflow run
+const (
+	StepStatusPending   string = "pending"
+	StepStatusRunning   string = "running"
+	StepStatusSucceeded string = "succeeded"
+	StepStatusFailed    string = "failed"
+	StepStatusSkipped   string = "skipped"
+)
+
+// Workflow run status values
+const (
+	WorkflowRunStatusRunning   string = "running"
+	WorkflowRunStatusSucceeded string = "succeeded"
+	WorkflowRunStatusFailed    string = "failed"
+)
+
+// The persisted progress of a workflow run
+// It's keyed by the TXID of the transaction that started the run
+// and saved to the logs directory of the workspace
+type WorkflowRun struct {
+	Transaction string `yaml:"transaction,omitempty" mapstructure:"transaction,omitempty" json:"transaction,omitempty"`
+	Workflow    string `yaml:"workflow,omitempty" mapstructure:"workflow,omitempty" json:"workflow,omitempty"`
+	Status      string `yaml:"status,omitempty" mapstructure:"status,omitempty" json:"status,omitempty"`
+	Date        string `yaml:"date,omitempty" mapstructure:"date,omitempty" json:"date,omitempty"`
+	// The TXIDs of the transactions that resumed the run
+	Resumes []string `yaml:"resumes,omitempty" mapstructure:"resumes,omitempty" json:"resumes,omitempty"`
+	// Additional env vars and mounts the run has been started with
+	Env       []string     `yaml:"env,omitempty" mapstructure:"env,omitempty" json:"env,omitempty"`
+	Mounts    []string     `yaml:"mounts,omitempty" mapstructure:"mounts,omitempty" json:"mounts,omitempty"`
+	Steps     []StepResult `yaml:"steps,omitempty" mapstructure:"steps,omitempty" json:"steps,omitempty"`
+	workspace *Workspace
+	// The run of the workflow that started this run with a nested workflow step
+	parent *WorkflowRun
+}
+
+// Returns the top-level run; nested runs are resumed through it
+func (r *WorkflowRun) getRoot() *WorkflowRun {
+	if r.parent != nil {
+		return r.parent.getRoot()
+	}
+	return r
+}
+
+func (r *WorkflowRun) GetStepResult(name string) *StepResult {
+	for i := 0; i < len(r.Steps); i++ {
+		if r.Steps[i].Name == name {
+			return &r.Steps[i]
+		}
+	}
+	return nil
+}
+
+func (r *WorkflowRun) update(tx *PolycrateTransaction, order []*Step, results map[string]*StepResult) error {
+	r.Steps = []StepResult{}
+	for _, step := range order {
+		r.Steps = append(r.Steps, *results[step.Name])
+	}
+	return r.Save(tx)
+}
+
+// Saves the progress of the run
+// Only top-level runs are saved; nested runs are part of the result of their step
+func (r *WorkflowRun) Save(tx *PolycrateTransaction) error {
+	if r.parent != nil {
+		return nil
+	}
+
+	path := r.workspace.getWorkflowRunPath(r.Transaction)
+	tx.Log.Debugf("Saving progress of workflow run at %s", path)
+
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+type StepResult struct {
+	Name           string `yaml:"name,omitempty" mapstructure:"name,omitempty" json:"name,omitempty"`
+	Status         string `yaml:"status,omitempty" mapstructure:"status,omitempty" json:"status,omitempty"`
+	AllowedFailure bool   `yaml:"allowed_failure,omitempty" mapstructure:"allowed_failure,omitempty" json:"allowed_failure,omitempty"`
+	Error          string `yaml:"error,omitempty" mapstructure:"error,omitempty" json:"error,omitempty"`
+	ExitCode       int    `yaml:"exit_code,omitempty" mapstructure:"exit_code,omitempty" json:"exit_code,omitempty"`
+	Transaction    string `yaml:"transaction,omitempty" mapstructure:"transaction,omitempty" json:"transaction,omitempty"`
+	Duration       string `yaml:"duration,omitempty" mapstructure:"duration,omitempty" json:"duration,omitempty"`
+	// The step has been skipped because its `when` condition was false
+	ConditionNotMet bool `yaml:"condition_not_met,omitempty" mapstructure:"condition_not_met,omitempty" json:"condition_not_met,omitempty"`
+	// Outputs the action of the step wrote to $POLYCRATE_OUTPUT
+	Outputs map[string]string `yaml:"outputs,omitempty" mapstructure:"outputs,omitempty" json:"outputs,omitempty"`
+	// Results of the expansions of a matrix step, one per block
+	Matrix []StepResult `yaml:"matrix,omitempty" mapstructure:"matrix,omitempty" json:"matrix,omitempty"`
+	// Results of the steps of the workflow a nested workflow step ran
+	Steps []StepResult `yaml:"steps,omitempty" mapstructure:"steps,omitempty" json:"steps,omitempty"`
 }
 
 func (c *Workflow) Inspect() {
@@ -110,31 +273,505 @@ func (w *Workflow) Run(tx *PolycrateTransaction, stepName string) error {
 			return err
 		}
 
-		return step.Run(tx)
+		err = step.Run(tx)
+		if goErrors.Is(err, ErrConditionNotMet) {
+			return nil
+		}
+		return err
+	}
+
+	return w.runGraph(tx, w.newRun(tx))
+}
+
+// Continues a previous run of the workflow
+// Steps that already succeeded in the previous run are skipped
+func (w *Workflow) Resume(tx *PolycrateTransaction, run *WorkflowRun) error {
+	tx.Log.Infof("Resuming Workflow run %s", run.Transaction)
+
+	// Check if a prompt is configured and execute it
+	if w.Prompt.Message != "" {
+		result := w.Prompt.Validate()
+		if !result {
+			return fmt.Errorf("not resuming workflow. user confirmation declined")
+		}
+	}
+
+	if len(w.Steps) == 0 {
+		return goErrors.New("no steps defined for workflow " + w.Name)
+	}
+
+	if run.Status == WorkflowRunStatusSucceeded {
+		tx.Log.Infof("Workflow run %s already succeeded. Nothing to resume", run.Transaction)
+		return nil
 	}
 
+	run.Resumes = append(run.Resumes, tx.TXID.String())
+	return w.runGraph(tx, run)
+}
+
+func (w *Workflow) newRun(tx *PolycrateTransaction) *WorkflowRun {
+	return &WorkflowRun{
+		Transaction: tx.TXID.String(),
+		Workflow:    w.Name,
+		Date:        time.Now().Format(time.RFC3339),
+		Env:         w.workspace.ExtraEnv,
+		Mounts:      w.workspace.ExtraMounts,
+		workspace:   w.workspace,
+	}
+}
+
+// Returns true if at least one step declares `needs`
+// Workflows without any `needs` run their steps in list order
+func (w *Workflow) isGraph() bool {
 	for _, step := range w.Steps {
-		step, err := w.GetStep(step.Name)
-		if err != nil {
-			return err
+		if len(step.Needs) > 0 {
+			return true
 		}
+	}
+	return false
+}
 
-		err = step.Run(tx)
-		if err != nil {
-			// Check AllowFailure, move on if it's OK
-			if !w.AllowFailure {
+// Returns the names of the steps a step depends on
+// In workflows without any `needs`, each step implicitly depends on the step before it
+func (w *Workflow) getStepDependencies(index int) []string {
+	if w.isGraph() {
+		return w.Steps[index].Needs
+	}
+	if index > 0 {
+		return []string{w.Steps[index-1].Name}
+	}
+	return nil
+}
+
+func (w *Workflow) getParallelism() int {
+	if workflowParallelism > 0 {
+		return workflowParallelism
+	}
+	if w.Parallelism > 0 {
+		return w.Parallelism
+	}
+	return WorkflowDefaultParallelism
+}
+
+// Validates the dependencies between the steps of the workflow
+// and returns the steps in topological order
+func (w *Workflow) ResolveStepGraph() ([]*Step, error) {
+	names := map[string]bool{}
+	for _, step := range w.getAllSteps() {
+		if names[step.Name] {
+			return nil, fmt.Errorf("workflow '%s' has more than one step named '%s'", w.Name, step.Name)
+		}
+		names[step.Name] = true
+	}
+
+	for _, step := range append(w.OnFailure, w.Finally...) {
+		if len(step.Needs) > 0 {
+			return nil, fmt.Errorf("step '%s' of workflow '%s' can't have `needs`: on_failure and finally steps run in order", step.Name, w.Name)
+		}
+	}
+
+	indexes := map[string]int{}
+	for i, step := range w.Steps {
+		indexes[step.Name] = i
+	}
+
+	for i, step := range w.Steps {
+		for _, need := range w.getStepDependencies(i) {
+			if _, ok := indexes[need]; !ok {
+				return nil, fmt.Errorf("step '%s' of workflow '%s' needs unknown step '%s'", step.Name, w.Name, need)
+			}
+		}
+	}
+
+	// Depth-first search, keeping track of the current path to report cycles
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(w.Steps))
+	path := []string{}
+	order := []*Step{}
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visited:
+			return nil
+		case visiting:
+			// Cut the path at the first occurrence of the step to get the cycle
+			cycle := []string{}
+			for j, name := range path {
+				if name == w.Steps[i].Name {
+					cycle = append(cycle, path[j:]...)
+					break
+				}
+			}
+			cycle = append(cycle, w.Steps[i].Name)
+			return fmt.Errorf("workflow '%s' has a dependency cycle: %s", w.Name, strings.Join(cycle, " -> "))
+		}
+
+		state[i] = visiting
+		path = append(path, w.Steps[i].Name)
+
+		for _, need := range w.getStepDependencies(i) {
+			if err := visit(indexes[need]); err != nil {
 				return err
 			}
-			log.Warnf("Step exited with an error: '%s'; continuing workflow execution because `allow_failure` is true", err)
 		}
 
-		// reloading workspace to account for new artifacts
-		//workspace.Reload(ctx)
+		path = path[:len(path)-1]
+		state[i] = visited
+		order = append(order, &w.Steps[i])
+		return nil
+	}
+
+	for i := range w.Steps {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+// Runs the steps of the workflow as a dependency graph
+// Steps whose dependencies have finished are started as soon as a slot is free
+// The progress is persisted to the given run after every step, so the run can be resumed later
+func (w *Workflow) runGraph(tx *PolycrateTransaction, run *WorkflowRun) error {
+	order, err := w.ResolveStepGraph()
+	if err != nil {
+		return err
+	}
+	w.run = run
+
+	parallelism := w.getParallelism()
+	tx.Log.Debugf("Running %d steps with a parallelism of %d", len(order), parallelism)
+
+	results := map[string]*StepResult{}
+	for _, step := range order {
+		results[step.Name] = &StepResult{
+			Name:   step.Name,
+			Status: StepStatusPending,
+		}
+
+		// Keep the result of steps that already succeeded in a previous attempt of the run
+		if previous := run.GetStepResult(step.Name); previous != nil && previous.Status == StepStatusSucceeded {
+			tx.Log.Infof("Skipping step '%s' because it already succeeded in transaction %s", step.Name, previous.Transaction)
+			*results[step.Name] = *previous
+		}
+	}
+
+	run.Status = WorkflowRunStatusRunning
+	if err := run.update(tx, order, results); err != nil {
+		return err
+	}
+
+	type stepDone struct {
+		step     *Step
+		tx       *PolycrateTransaction
+		run      *Step
+		duration time.Duration
+		err      error
+	}
+	done := make(chan stepDone)
+
+	running := 0
+	halted := false
+
+	for {
+		// Don't start new steps after a step failed or the transaction has been cancelled
+		if tx.Context.Err() != nil {
+			halted = true
+		}
+
+		if !halted {
+			// The steps are in topological order, so the status of all
+			// dependencies of a step is final before the step is checked
+			for _, step := range order {
+				if running >= parallelism {
+					break
+				}
+
+				result := results[step.Name]
+				if result.Status != StepStatusPending {
+					continue
+				}
+
+				ready, skip := w.checkStepDependencies(step, results)
+				if skip {
+					tx.Log.Warnf("Skipping step '%s' because a step it needs did not succeed", step.Name)
+					result.Status = StepStatusSkipped
+					continue
+				}
+				if !ready {
+					continue
+				}
+
+				result.Status = StepStatusRunning
+				result.Error = ""
+				result.ConditionNotMet = false
+				running++
+
+				// Hand a copy of the current results to the step, so it can use them in its snapshot
+				stepResults := map[string]*StepResult{}
+				for name, result := range results {
+					_result := *result
+					stepResults[name] = &_result
+				}
+
+				go func(step *Step) {
+					start := time.Now()
+					stx, run, err := w.runStep(tx, step, stepResults, WorkflowFailure{})
+					done <- stepDone{step, stx, run, time.Since(start), err}
+				}(step)
+			}
+		}
+
+		if running == 0 {
+			break
+		}
+
+		d := <-done
+		running--
+
+		result := results[d.step.Name]
+		result.Transaction = d.tx.TXID.String()
+		result.ExitCode = d.tx.ExitCode
+		result.Outputs = d.tx.Outputs
+		result.Matrix = d.run.matrixResults
+		result.Steps = d.run.workflowResults
+		result.Duration = d.duration.Round(time.Second).String()
+
+		if goErrors.Is(d.err, ErrConditionNotMet) {
+			result.Status = StepStatusSkipped
+			result.ConditionNotMet = true
+		} else if d.err != nil {
+			result.Status = StepStatusFailed
+			result.Error = d.err.Error()
+
+			// Check AllowFailure, move on if it's OK
+			if w.AllowFailure || d.step.AllowFailure {
+				result.AllowedFailure = true
+				tx.Log.Warnf("Step '%s' exited with an error: '%s'; continuing workflow execution because `allow_failure` is true", d.step.Name, d.err)
+			} else {
+				tx.Log.Errorf("Step '%s' exited with an error: '%s'; not starting any further steps", d.step.Name, d.err)
+				halted = true
+			}
+		} else {
+			result.Status = StepStatusSucceeded
+		}
+
+		if err := run.update(tx, order, results); err != nil {
+			tx.Log.Warnf("Failed to save progress of workflow run: %s", err)
+		}
+	}
+
+	var failure WorkflowFailure
+	for _, step := range order {
+		result := results[step.Name]
+		if result.Status == StepStatusPending {
+			result.Status = StepStatusSkipped
+		}
+		if result.Status == StepStatusFailed && !result.AllowedFailure && failure.Step == "" {
+			failure = WorkflowFailure{
+				Step:     result.Name,
+				ExitCode: result.ExitCode,
+				Error:    result.Error,
+			}
+		}
+	}
+
+	// A cancelled run (e.g. CTRL-C or a timeout) counts as failed
+	if failure.Step == "" && tx.Context.Err() != nil {
+		failure.Error = fmt.Sprintf("workflow '%s' has been cancelled: %s", w.Name, tx.Context.Err())
+	}
+
+	// Run the on_failure and finally steps
+	// They run in transactions of their own, so they run for cancelled runs as well
+	hooks := []Step{}
+	if failure.Step != "" || failure.Error != "" {
+		hooks = append(hooks, w.OnFailure...)
+	}
+	hooks = append(hooks, w.Finally...)
+
+	for i := range hooks {
+		hook, err := w.GetStep(hooks[i].Name)
+		if err != nil {
+			return err
+		}
+
+		result := &StepResult{
+			Name:   hook.Name,
+			Status: StepStatusSkipped,
+		}
+		results[hook.Name] = result
+		order = append(order, hook)
+
+		stepResults := map[string]*StepResult{}
+		for name, result := range results {
+			_result := *result
+			stepResults[name] = &_result
+		}
+
+		start := time.Now()
+		stx, run, err := w.runStepInTransaction(polycrate.DetachedSubTransaction(tx, WorkflowHookTimeout), hook, stepResults, failure)
+
+		result.Transaction = stx.TXID.String()
+		result.ExitCode = stx.ExitCode
+		result.Outputs = stx.Outputs
+		result.Steps = run.workflowResults
+		result.Duration = time.Since(start).Round(time.Second).String()
+
+		if goErrors.Is(err, ErrConditionNotMet) {
+			result.ConditionNotMet = true
+		} else if err != nil {
+			result.Status = StepStatusFailed
+			result.Error = err.Error()
+			tx.Log.Errorf("Step '%s' exited with an error: '%s'", hook.Name, err)
+		} else {
+			result.Status = StepStatusSucceeded
+		}
+	}
+
+	failed := []string{}
+	for _, step := range order {
+		result := results[step.Name]
+		if result.Status == StepStatusFailed && !result.AllowedFailure {
+			failed = append(failed, step.Name)
+		}
+	}
+
+	run.Status = WorkflowRunStatusSucceeded
+	if len(failed) > 0 || tx.Context.Err() != nil {
+		run.Status = WorkflowRunStatusFailed
+	}
+	if err := run.update(tx, order, results); err != nil {
+		tx.Log.Warnf("Failed to save progress of workflow run: %s", err)
 	}
 
+	summary := w.formatStepSummary(run, order, results)
+	fmt.Print(summary)
+	tx.SetOutput(summary)
+
+	if len(failed) > 0 {
+		return fmt.Errorf("workflow '%s' failed: step(s) %s failed. Resume with 'polycrate workflows resume %s'", w.Name, strings.Join(failed, ", "), run.getRoot().Transaction)
+	}
+	if err := tx.Context.Err(); err != nil {
+		return fmt.Errorf("workflow '%s' has been cancelled. Resume with 'polycrate workflows resume %s'", w.Name, run.getRoot().Transaction)
+	}
 	return nil
 }
 
+// Checks if all dependencies of a step have finished
+// skip is true if a dependency failed (without allow_failure) or has been skipped
+func (w *Workflow) checkStepDependencies(step *Step, results map[string]*StepResult) (ready bool, skip bool) {
+	index := 0
+	for i := range w.Steps {
+		if w.Steps[i].Name == step.Name {
+			index = i
+		}
+	}
+
+	ready = true
+	for _, need := range w.getStepDependencies(index) {
+		dependency := results[need]
+
+		switch dependency.Status {
+		case StepStatusSucceeded:
+			continue
+		case StepStatusFailed:
+			if dependency.AllowedFailure {
+				continue
+			}
+			return false, true
+		case StepStatusSkipped:
+			// Steps skipped by their `when` condition don't block the steps that need them
+			if dependency.ConditionNotMet {
+				continue
+			}
+			return false, true
+		default:
+			ready = false
+		}
+	}
+	return ready, false
+}
+
+// Runs a step in its own sub-transaction against a copy of the workspace
+// so steps running in parallel don't share env vars, mounts or the current block/action
+// Returns the (stopped) sub-transaction and the step that ran in the copy of the workspace
+// The returned step is never nil; it carries the results of matrix expansions and nested workflows
+func (w *Workflow) runStep(tx *PolycrateTransaction, step *Step, results map[string]*StepResult, failure WorkflowFailure) (*PolycrateTransaction, *Step, error) {
+	return w.runStepInTransaction(polycrate.SubTransaction(tx), step, results, failure)
+}
+
+// Runs the step in the given sub-transaction; the transaction is stopped afterwards
+func (w *Workflow) runStepInTransaction(stx *PolycrateTransaction, step *Step, results map[string]*StepResult, failure WorkflowFailure) (*PolycrateTransaction, *Step, error) {
+	stx.Log.SetField("workflow", w.Name)
+	stx.Log.SetField("step", step.Name)
+
+	defer func() {
+		transactionLock.Lock()
+		defer transactionLock.Unlock()
+		stx.Stop()
+	}()
+
+	workspace, err := w.workspace.Clone(stx, results, failure)
+	if err != nil {
+		return stx, &Step{}, err
+	}
+
+	workflow, err := workspace.GetWorkflow(w.Name)
+	if err != nil {
+		return stx, &Step{}, err
+	}
+	workspace.registerCurrentWorkflow(workflow)
+
+	_step, err := workflow.GetStep(step.Name)
+	if err != nil {
+		return stx, &Step{}, err
+	}
+
+	err = _step.Run(stx)
+	return stx, _step, err
+}
+
+func (w *Workflow) formatStepSummary(run *WorkflowRun, order []*Step, results map[string]*StepResult) string {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "\nWorkflow '%s' (%s)\n", w.Name, run.Transaction)
+
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tSTATUS\tDURATION\tTRANSACTION\tERROR")
+	for _, step := range order {
+		formatStepResult(tw, results[step.Name], "")
+	}
+	tw.Flush()
+
+	return buf.String()
+}
+
+// Writes a row for the result of a step to the summary
+// The expansions of matrix steps and the steps of nested workflows are listed indented below the step
+func formatStepResult(tw *tabwriter.Writer, result *StepResult, indent string) {
+	status := result.Status
+	if result.AllowedFailure {
+		status = status + " (allowed)"
+	}
+	if result.ConditionNotMet {
+		status = status + " (condition)"
+	}
+	fmt.Fprintf(tw, "%s%s\t%s\t%s\t%s\t%s\n", indent, result.Name, status, result.Duration, result.Transaction, result.Error)
+
+	for i := range result.Matrix {
+		formatStepResult(tw, &result.Matrix[i], indent+"  ")
+	}
+	for i := range result.Steps {
+		formatStepResult(tw, &result.Steps[i], indent+"  ")
+	}
+}
+
 // func (w *Workflow) Run(ctx context.Context) error {
 // 	log := polycrate.GetContextLogger(ctx)
 
@@ -186,16 +823,39 @@ func (s *Step) Run(tx *PolycrateTransaction) error {
 	// Reloading Workspace to discover new files
 	//workspace.load().Flush()
 
-	// Check if an a block and an action have been configured
-	if s.Block == "" {
-		return goErrors.New("no block configured")
-	}
-	if s.Action == "" {
-		return goErrors.New("no action configured")
+	// Check if an a block (or matrix) and an action or a workflow have been configured
+	if s.RunWorkflow == "" {
+		if s.Block == "" && s.Matrix == nil {
+			return goErrors.New("no block configured")
+		}
+		if s.Action == "" {
+			return goErrors.New("no action configured")
+		}
 	}
 
 	workspace.registerCurrentStep(s)
 
+	// Check if a condition is configured and evaluate it
+	// against a snapshot that contains the block and action of the step
+	if s.When != "" {
+		snapshot := workspace.GetSnapshot()
+		if block, err := workspace.GetBlock(s.Block); err == nil {
+			snapshot.Block = block
+			if action, err := block.GetAction(s.Action); err == nil {
+				snapshot.Action = action
+			}
+		}
+
+		result, err := snapshot.EvaluateCondition(s.When)
+		if err != nil {
+			return fmt.Errorf("failed to evaluate condition of step '%s': %s", s.Name, err)
+		}
+		if !result {
+			tx.Log.Infof("Not running step. Condition not met: %s", s.When)
+			return ErrConditionNotMet
+		}
+	}
+
 	// Check for prompt
 	var runStep = true
 	if s.Prompt.Message != "" {
@@ -203,7 +863,35 @@ func (s *Step) Run(tx *PolycrateTransaction) error {
 	}
 
 	if runStep {
-		err := workspace.RunAction(tx, s.Block, s.Action)
+		if s.Matrix != nil {
+			return s.runMatrix(tx)
+		}
+
+		if s.RunWorkflow != "" {
+			if s.Retries == 0 && s.Timeout == "" {
+				return s.runWorkflow(tx)
+			}
+
+			// Each attempt runs the nested workflow in a sub-transaction that is cancelled with the attempt
+			return tx.RunWithRetries(fmt.Sprintf("step %s", s.Name), s.Retries, s.RetryDelay, s.Timeout, func(ctx context.Context, attempt int) error {
+				atx := polycrate.SubTransactionWithContext(tx, ctx)
+				defer func() {
+					transactionLock.Lock()
+					defer transactionLock.Unlock()
+					atx.Stop()
+				}()
+				return s.runWorkflow(atx)
+			})
+		}
+
+		inputs, err := s.renderInputs(workspace.GetSnapshot())
+		if err != nil {
+			return err
+		}
+		workspace.SetInputs(inputs)
+
+		s.applyRetryPolicy(workspace, s.Block)
+		err = workspace.RunAction(tx, s.Block, s.Action)
 		if err != nil {
 			return err
 		}
@@ -214,6 +902,506 @@ func (s *Step) Run(tx *PolycrateTransaction) error {
 	return nil
 }
 
+// Runs the action of a matrix step for all matching blocks in parallel
+// Each expansion runs in its own sub-transaction against its own copy of the workspace
+func (s *Step) runMatrix(tx *PolycrateTransaction) error {
+	workspace := s.workflow.workspace
+
+	blocks := workspace.SelectBlocks(s.Matrix.Labels, s.Matrix.From)
+	if len(blocks) == 0 {
+		return fmt.Errorf("matrix of step '%s' doesn't match any blocks", s.Name)
+	}
+
+	tx.Log.Infof("Running action '%s' for %d blocks", s.Action, len(blocks))
+
+	s.matrixResults = make([]StepResult, len(blocks))
+	slots := make(chan struct{}, s.workflow.getParallelism())
+
+	var wg sync.WaitGroup
+	for i, block := range blocks {
+		wg.Add(1)
+		go func(result *StepResult, block *Block) {
+			defer wg.Done()
+
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			result.Name = block.Name
+
+			// Don't start further expansions if the transaction has been cancelled
+			if tx.Context.Err() != nil {
+				result.Status = StepStatusSkipped
+				return
+			}
+
+			start := time.Now()
+			mtx, err := s.runMatrixExpansion(tx, block)
+
+			result.Transaction = mtx.TXID.String()
+			result.Duration = time.Since(start).Round(time.Second).String()
+			result.Outputs = mtx.Outputs
+			if goErrors.Is(err, ErrConditionNotMet) {
+				result.Status = StepStatusSkipped
+				result.ConditionNotMet = true
+			} else if err != nil {
+				result.Status = StepStatusFailed
+				result.Error = err.Error()
+			} else {
+				result.Status = StepStatusSucceeded
+			}
+		}(&s.matrixResults[i], block)
+	}
+	wg.Wait()
+
+	failed := []string{}
+	skipped := 0
+	for _, result := range s.matrixResults {
+		if result.ConditionNotMet {
+			skipped++
+		} else if result.Status != StepStatusSucceeded {
+			failed = append(failed, result.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("action '%s' failed for block(s) %s", s.Action, strings.Join(failed, ", "))
+	}
+
+	// The step counts as skipped if the action didn't run for any block
+	if skipped == len(s.matrixResults) {
+		return ErrConditionNotMet
+	}
+	return nil
+}
+
+// Runs the workflow referenced by a nested workflow step as a graph of its own
+// The steps of the nested workflow run in sub-transactions of the step's transaction
+func (s *Step) runWorkflow(tx *PolycrateTransaction) error {
+	workflow, err := s.workflow.workspace.GetWorkflow(s.RunWorkflow)
+	if err != nil {
+		return err
+	}
+
+	tx.Log.Infof("Running nested workflow '%s'", workflow.Name)
+
+	// Check if a prompt is configured and execute it
+	if workflow.Prompt.Message != "" {
+		result := workflow.Prompt.Validate()
+		if !result {
+			return fmt.Errorf("not running workflow. user confirmation declined")
+		}
+	}
+
+	if len(workflow.Steps) == 0 {
+		return goErrors.New("no steps defined for workflow " + workflow.Name)
+	}
+
+	run := workflow.newRun(tx)
+	run.parent = s.workflow.run
+	err = workflow.runGraph(tx, run)
+	s.workflowResults = run.Steps
+	return err
+}
+
+func (s *Step) runMatrixExpansion(tx *PolycrateTransaction, block *Block) (*PolycrateTransaction, error) {
+	mtx := polycrate.SubTransaction(tx)
+	mtx.Log.SetField("block", block.Name)
+
+	defer func() {
+		transactionLock.Lock()
+		defer transactionLock.Unlock()
+		mtx.Stop()
+	}()
+
+	workspace, err := s.workflow.workspace.Clone(mtx, s.workflow.workspace.stepResults, s.workflow.workspace.workflowFailure)
+	if err != nil {
+		return mtx, err
+	}
+
+	workflow, err := workspace.GetWorkflow(s.workflow.Name)
+	if err != nil {
+		return mtx, err
+	}
+	step, err := workflow.GetStep(s.Name)
+	if err != nil {
+		return mtx, err
+	}
+	workspace.registerCurrentWorkflow(workflow)
+	workspace.registerCurrentStep(step)
+
+	// Inputs can use the block of the expansion
+	snapshot := workspace.GetSnapshot()
+	if _block, err := workspace.GetBlock(block.Name); err == nil {
+		snapshot.Block = _block
+	}
+	inputs, err := step.renderInputs(snapshot)
+	if err != nil {
+		return mtx, err
+	}
+	workspace.SetInputs(inputs)
+
+	step.applyRetryPolicy(workspace, block.Name)
+	err = workspace.RunAction(mtx, block.Name, s.Action)
+	return mtx, err
+}
+
+// Applies the retry policy of the step to its action in the given workspace
+// A policy configured on the step replaces the policy of the action instead of
+// wrapping it, so the attempts of the step and the action don't multiply
+func (s *Step) applyRetryPolicy(workspace *Workspace, blockName string) {
+	if s.Retries == 0 && s.RetryDelay == "" && s.Timeout == "" {
+		return
+	}
+
+	// A missing block or action is reported when the action is run
+	block, err := workspace.GetBlock(blockName)
+	if err != nil {
+		return
+	}
+	action, err := block.GetAction(s.Action)
+	if err != nil {
+		return
+	}
+
+	action.Retries = s.Retries
+	action.RetryDelay = s.RetryDelay
+	action.Timeout = s.Timeout
+}
+
+// The resolved execution plan of a workflow
+// It's created by `polycrate workflows plan` without starting any containers
+type WorkflowPlan struct {
+	Workflow    string     `yaml:"workflow,omitempty" mapstructure:"workflow,omitempty" json:"workflow,omitempty"`
+	Parallelism int        `yaml:"parallelism,omitempty" mapstructure:"parallelism,omitempty" json:"parallelism,omitempty"`
+	Steps       []StepPlan `yaml:"steps,omitempty" mapstructure:"steps,omitempty" json:"steps,omitempty"`
+}
+
+// The resolved execution plan of a step
+type StepPlan struct {
+	Name string `yaml:"name,omitempty" mapstructure:"name,omitempty" json:"name,omitempty"`
+	// steps, on_failure or finally
+	Stage  string `yaml:"stage,omitempty" mapstructure:"stage,omitempty" json:"stage,omitempty"`
+	Block  string `yaml:"block,omitempty" mapstructure:"block,omitempty" json:"block,omitempty"`
+	Action string `yaml:"action,omitempty" mapstructure:"action,omitempty" json:"action,omitempty"`
+	// The workflow a nested workflow step runs
+	RunWorkflow string   `yaml:"run_workflow,omitempty" mapstructure:"run_workflow,omitempty" json:"run_workflow,omitempty"`
+	Needs       []string `yaml:"needs,omitempty" mapstructure:"needs,omitempty" json:"needs,omitempty"`
+	When        string   `yaml:"when,omitempty" mapstructure:"when,omitempty" json:"when,omitempty"`
+	Image       string   `yaml:"image,omitempty" mapstructure:"image,omitempty" json:"image,omitempty"`
+	Workdir     string   `yaml:"workdir,omitempty" mapstructure:"workdir,omitempty" json:"workdir,omitempty"`
+	Mounts      []string `yaml:"mounts,omitempty" mapstructure:"mounts,omitempty" json:"mounts,omitempty"`
+	// Resolved inputs of the action
+	Inputs map[string]interface{} `yaml:"inputs,omitempty" mapstructure:"inputs,omitempty" json:"inputs,omitempty"`
+	// Names of the env vars; values are left out as they might contain secrets
+	Env    []string `yaml:"env,omitempty" mapstructure:"env,omitempty" json:"env,omitempty"`
+	Script []string `yaml:"script,omitempty" mapstructure:"script,omitempty" json:"script,omitempty"`
+	// Directory of the manifests the action applies
+	Manifests string `yaml:"manifests,omitempty" mapstructure:"manifests,omitempty" json:"manifests,omitempty"`
+	// The step can't be run, e.g. because its block doesn't exist
+	Error string `yaml:"error,omitempty" mapstructure:"error,omitempty" json:"error,omitempty"`
+	// Plans of the expansions of a matrix step, one per block
+	Matrix []StepPlan `yaml:"matrix,omitempty" mapstructure:"matrix,omitempty" json:"matrix,omitempty"`
+	// Plans of the steps of the workflow a nested workflow step runs
+	Steps []StepPlan `yaml:"steps,omitempty" mapstructure:"steps,omitempty" json:"steps,omitempty"`
+}
+
+// Resolves all steps of the workflow to what they would run
+// Steps are listed in the order they would be started, followed by the on_failure and finally steps
+func (w *Workflow) Plan(tx *PolycrateTransaction) (*WorkflowPlan, error) {
+	order, err := w.ResolveStepGraph()
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := map[string]int{}
+	for i, step := range w.Steps {
+		indexes[step.Name] = i
+	}
+
+	plan := &WorkflowPlan{
+		Workflow:    w.Name,
+		Parallelism: w.getParallelism(),
+	}
+
+	for _, step := range order {
+		stepPlan, err := step.plan(tx, "steps")
+		if err != nil {
+			return nil, err
+		}
+		stepPlan.Needs = w.getStepDependencies(indexes[step.Name])
+		plan.Steps = append(plan.Steps, stepPlan)
+	}
+
+	for i := range w.OnFailure {
+		stepPlan, err := w.OnFailure[i].plan(tx, "on_failure")
+		if err != nil {
+			return nil, err
+		}
+		plan.Steps = append(plan.Steps, stepPlan)
+	}
+
+	for i := range w.Finally {
+		stepPlan, err := w.Finally[i].plan(tx, "finally")
+		if err != nil {
+			return nil, err
+		}
+		plan.Steps = append(plan.Steps, stepPlan)
+	}
+
+	return plan, nil
+}
+
+func (s *Step) plan(tx *PolycrateTransaction, stage string) (StepPlan, error) {
+	workspace := s.workflow.workspace
+
+	plan := StepPlan{
+		Name:        s.Name,
+		Stage:       stage,
+		Block:       s.Block,
+		Action:      s.Action,
+		RunWorkflow: s.RunWorkflow,
+		When:        s.When,
+	}
+
+	switch {
+	case s.RunWorkflow != "":
+		workflow, err := workspace.GetWorkflow(s.RunWorkflow)
+		if err != nil {
+			return plan, err
+		}
+
+		nestedPlan, err := workflow.Plan(tx)
+		if err != nil {
+			return plan, err
+		}
+		plan.Steps = nestedPlan.Steps
+	case s.Matrix != nil:
+		blocks := workspace.SelectBlocks(s.Matrix.Labels, s.Matrix.From)
+		if len(blocks) == 0 {
+			plan.Error = fmt.Sprintf("matrix of step '%s' doesn't match any blocks", s.Name)
+		}
+
+		for _, block := range blocks {
+			expansion := StepPlan{
+				Name:   block.Name,
+				Block:  block.Name,
+				Action: s.Action,
+			}
+			s.planAction(tx, &expansion)
+			plan.Matrix = append(plan.Matrix, expansion)
+		}
+	default:
+		s.planAction(tx, &plan)
+	}
+
+	return plan, nil
+}
+
+// Resolves the block and action of a step plan the same way Workspace.RunAction and Action.Run do
+// Problems are reported in the plan instead of failing the whole plan
+func (s *Step) planAction(tx *PolycrateTransaction, plan *StepPlan) {
+	// Like the step itself, the plan uses its own copy of the workspace
+	// so registering env vars and templating the script doesn't affect other steps
+	workspace, err := s.workflow.workspace.Clone(tx, nil, WorkflowFailure{})
+	if err != nil {
+		plan.Error = err.Error()
+		return
+	}
+
+	workflow, err := workspace.GetWorkflow(s.workflow.Name)
+	if err != nil {
+		plan.Error = err.Error()
+		return
+	}
+	step, err := workflow.GetStep(s.Name)
+	if err != nil {
+		plan.Error = err.Error()
+		return
+	}
+
+	block, err := workspace.GetBlock(plan.Block)
+	if err != nil {
+		plan.Error = err.Error()
+		return
+	}
+
+	action, err := block.GetAction(plan.Action)
+	if err != nil {
+		plan.Error = err.Error()
+		return
+	}
+
+	if block.Template {
+		plan.Error = "this is a template block. not running action"
+		return
+	}
+
+	workspace.registerCurrentWorkflow(workflow)
+	workspace.registerCurrentStep(step)
+	workspace.registerCurrentAction(action)
+	workspace.registerCurrentBlock(block)
+	action.registerEnvVars(tx)
+
+	// Inputs are never prompted for in a plan
+	values, err := step.renderInputs(workspace.GetSnapshot())
+	if err != nil {
+		plan.Error = err.Error()
+		return
+	}
+	inputs, err := action.ResolveInputs(values, false)
+	if err != nil {
+		plan.Error = err.Error()
+	}
+	plan.Inputs = inputs
+	workspace.registerCurrentInputs(action, inputs)
+
+	// Manifests are applied by polycrate itself, there's no script or container
+	if action.Manifests != "" {
+		plan.Manifests = action.Manifests
+		return
+	}
+
+	// The snapshot is only saved when the action runs
+	workspace.registerEnvVar("POLYCRATE_WORKSPACE_SNAPSHOT_YAML", "")
+	if len(action.Script) > 0 {
+		workspace.registerEnvVar("ANSIBLE_VARS_ENABLED", "polycrate_vars")
+	}
+
+	if err := action.templateScript(workspace.GetSnapshot()); err != nil {
+		plan.Error = err.Error()
+		return
+	}
+
+	script, err := action.RenderExecutionScript(workspace.GetSnapshot(), "$POLYCRATE_WORKSPACE_SNAPSHOT_YAML")
+	if err != nil {
+		plan.Error = err.Error()
+	}
+	plan.Script = script
+
+	for envVar := range workspace.env {
+		plan.Env = append(plan.Env, envVar)
+	}
+	sort.Strings(plan.Env)
+
+	// Mounts, image and workdir don't apply to actions running with --local
+	if !local {
+		container := action.getContainerConfig()
+		plan.Image = workspace.getContainerImage(container)
+		plan.Workdir = block.Workdir.Path
+
+		workspace.registerMount(workspace.getOutputPath(), workspace.getOutputPath())
+		for mount := range workspace.mounts {
+			plan.Mounts = append(plan.Mounts, strings.Join([]string{mount, workspace.mounts[mount]}, ":"))
+		}
+		plan.Mounts = append(plan.Mounts, container.Mounts...)
+		sort.Strings(plan.Mounts)
+	}
+}
+
+// Prints the plan as a table (followed by the details of each action) or as json/yaml
+func (p *WorkflowPlan) Print(format string) error {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(p, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", data)
+	case "yaml":
+		data, err := yaml.Marshal(p)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", data)
+	case "table":
+		fmt.Printf("Workflow '%s' (parallelism: %d)\n", p.Workflow, p.Parallelism)
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "STEP\tSTAGE\tBLOCK\tACTION\tIMAGE\tWORKDIR\tNEEDS\tWHEN\tERROR")
+		for i := range p.Steps {
+			formatStepPlan(tw, &p.Steps[i], "")
+		}
+		tw.Flush()
+
+		for i := range p.Steps {
+			printStepPlanDetails(&p.Steps[i], p.Steps[i].Name)
+		}
+	default:
+		return fmt.Errorf("unknown format: %s. Use one of table, json, yaml", format)
+	}
+	return nil
+}
+
+// Writes a row for a step plan to the table
+// The expansions of matrix steps and the steps of nested workflows are listed indented below the step
+func formatStepPlan(tw *tabwriter.Writer, plan *StepPlan, indent string) {
+	block := plan.Block
+	if plan.RunWorkflow != "" {
+		block = "workflow:" + plan.RunWorkflow
+	}
+	fmt.Fprintf(tw, "%s%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", indent, plan.Name, plan.Stage, block, plan.Action, plan.Image, plan.Workdir, strings.Join(plan.Needs, ","), plan.When, plan.Error)
+
+	for i := range plan.Matrix {
+		formatStepPlan(tw, &plan.Matrix[i], indent+"  ")
+	}
+	for i := range plan.Steps {
+		formatStepPlan(tw, &plan.Steps[i], indent+"  ")
+	}
+}
+
+// Prints the mounts, env vars and script of every action of a step plan
+func printStepPlanDetails(plan *StepPlan, path string) {
+	if plan.Script != nil || plan.Manifests != "" {
+		fmt.Printf("\n%s (%s:%s)\n", path, plan.Block, plan.Action)
+		if len(plan.Mounts) > 0 {
+			fmt.Printf("  mounts:\n")
+			for _, mount := range plan.Mounts {
+				fmt.Printf("    %s\n", mount)
+			}
+		}
+		if len(plan.Inputs) > 0 {
+			names := []string{}
+			for name := range plan.Inputs {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			fmt.Printf("  inputs:\n")
+			for _, name := range names {
+				fmt.Printf("    %s=%v\n", name, plan.Inputs[name])
+			}
+		}
+		if plan.Manifests != "" {
+			fmt.Printf("  manifests: %s\n", plan.Manifests)
+		} else {
+			fmt.Printf("  env: %s\n", strings.Join(plan.Env, ", "))
+			fmt.Printf("  script:\n")
+			for _, line := range plan.Script {
+				fmt.Printf("    %s\n", line)
+			}
+		}
+	}
+
+	for i := range plan.Matrix {
+		printStepPlanDetails(&plan.Matrix[i], path+"/"+plan.Matrix[i].Name)
+	}
+	for i := range plan.Steps {
+		printStepPlanDetails(&plan.Steps[i], path+"/"+plan.Steps[i].Name)
+	}
+}
+
+// Renders the templates in the input values of the step
+func (s *Step) renderInputs(snapshot WorkspaceSnapshot) (map[string]string, error) {
+	inputs := map[string]string{}
+	for name, value := range s.Inputs {
+		rendered, err := renderTemplates([]string{value}, snapshot)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render input '%s' of step '%s': %s", name, s.Name, err)
+		}
+		inputs[name] = rendered[0]
+	}
+	return inputs, nil
+}
+
 func (c *Workflow) validate() error {
 	err := validate.Struct(c)
 
@@ -269,20 +1457,53 @@ func (c *Step) validate() error {
 	// }
 	// log.Debug("Found Block at " + blockDir)
 
+	if c.Matrix != nil {
+		if err := c.Matrix.validate(); err != nil {
+			return fmt.Errorf("matrix of step '%s' is invalid: %s", c.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// A matrix must select blocks by at least one criterion, so a typo doesn't run the action for all blocks
+func (m *StepMatrix) validate() error {
+	if len(m.Labels) == 0 && m.From == "" {
+		return goErrors.New("either `labels` or `from` must be set")
+	}
+	for key, value := range m.Labels {
+		if key == "" || value == "" {
+			return fmt.Errorf("label '%s' must have a name and a value", key)
+		}
+	}
 	return nil
 }
 
 func (c *Workflow) GetStep(name string) (*Step, error) {
 
 	//for _, block := range c.Blocks {
-	for i := 0; i < len(c.Steps); i++ {
-		step := &c.Steps[i]
+	for _, step := range c.getAllSteps() {
 		if step.Name == name {
 			return step, nil
 		}
 	}
 	return nil, fmt.Errorf("step not found: %s", name)
 }
+
+// Returns the steps, on_failure and finally steps of the workflow
+func (c *Workflow) getAllSteps() []*Step {
+	steps := []*Step{}
+	for i := 0; i < len(c.Steps); i++ {
+		steps = append(steps, &c.Steps[i])
+	}
+	for i := 0; i < len(c.OnFailure); i++ {
+		steps = append(steps, &c.OnFailure[i])
+	}
+	for i := 0; i < len(c.Finally); i++ {
+		steps = append(steps, &c.Finally[i])
+	}
+	return steps
+}
 func (c *Workflow) GetStepByIndex(index int) *Step {
 
 	//for _, block := range c.Blocks {
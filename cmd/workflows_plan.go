@@ -0,0 +1,51 @@
+/*
+Copyright © 2021 Fabian Peter <fp@ayedo.de>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var workflowPlanFormat string
+
+var planWorkflowCmd = &cobra.Command{
+	Use:   "plan WORKFLOW",
+	Short: "Show what a Workflow would run",
+	Long:  `Resolve every step of a Workflow to its block, action, image, workdir, mounts, env vars and rendered script without running anything.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		_w := cmd.Flags().Lookup("workspace").Value.String()
+
+		tx := polycrate.Transaction().SetCommand(cmd)
+		defer tx.Stop()
+
+		workspace, err := polycrate.LoadWorkspace(tx, _w, true)
+		if err != nil {
+			tx.Log.Fatal(err)
+		}
+
+		err = workspace.PlanWorkflow(tx, args[0], workflowPlanFormat)
+		if err != nil {
+			tx.Log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	planWorkflowCmd.Flags().StringVar(&workflowPlanFormat, "format", "table", "Format of the plan (table, json or yaml)")
+
+	workflowsCmd.AddCommand(planWorkflowCmd)
+}
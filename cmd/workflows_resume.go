@@ -0,0 +1,50 @@
+/*
+Copyright © 2021 Fabian Peter <fp@ayedo.de>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var resumeWorkflowCmd = &cobra.Command{
+	Use:   "resume TXID",
+	Short: "Resume a Workflow run",
+	Long:  `Resume a previous Workflow run. Steps that already succeeded in the run are skipped.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		_w := cmd.Flags().Lookup("workspace").Value.String()
+
+		tx := polycrate.Transaction().SetCommand(cmd)
+		defer tx.Stop()
+
+		workspace, err := polycrate.LoadWorkspace(tx, _w, true)
+		if err != nil {
+			tx.Log.Fatal(err)
+		}
+
+		err = workspace.ResumeWorkflow(tx, args[0])
+		if err != nil {
+			tx.Log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	resumeWorkflowCmd.Flags().IntVar(&workflowParallelism, "parallelism", 0, "Maximum number of steps running at the same time (overrides the parallelism of the workflow)")
+	resumeWorkflowCmd.Flags().BoolVar(&waitForLock, "wait", false, "Wait for actions of the same block running in other processes instead of failing")
+
+	workflowsCmd.AddCommand(resumeWorkflowCmd)
+}
@@ -20,7 +20,7 @@ import (
 	"github.com/spf13/cobra"
 )
 
-//var workflowName string
+// var workflowName string
 var stepName string
 var stepIndex int
 
@@ -52,6 +52,8 @@ var runWorkflowCmd = &cobra.Command{
 func init() {
 	runWorkflowCmd.Flags().StringVar(&stepName, "step", "", "The name of the step to be run")
 	runWorkflowCmd.Flags().IntVar(&stepIndex, "step-index", -1, "The index of the step to be executed. Currently no-op")
+	runWorkflowCmd.Flags().IntVar(&workflowParallelism, "parallelism", 0, "Maximum number of steps running at the same time (overrides the parallelism of the workflow)")
+	runWorkflowCmd.Flags().BoolVar(&waitForLock, "wait", false, "Wait for actions of the same block running in other processes instead of failing")
 
 	workflowsCmd.AddCommand(runWorkflowCmd)
 }
@@ -0,0 +1,377 @@
+/*
+Copyright © 2021 Fabian Peter <fp@ayedo.de>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func stepNames(steps []*Step) []string {
+	names := []string{}
+	for _, step := range steps {
+		names = append(names, step.Name)
+	}
+	return names
+}
+
+func TestResolveStepGraph(t *testing.T) {
+	tests := []struct {
+		name     string
+		workflow Workflow
+		order    []string
+		err      string
+	}{
+		{
+			name: "sequential steps keep their order",
+			workflow: Workflow{Name: "wf", Steps: []Step{
+				{Name: "aa"}, {Name: "bb"}, {Name: "cc"},
+			}},
+			order: []string{"aa", "bb", "cc"},
+		},
+		{
+			name: "needs are resolved before the step",
+			workflow: Workflow{Name: "wf", Steps: []Step{
+				{Name: "deploy", Needs: []string{"build", "test"}},
+				{Name: "test", Needs: []string{"build"}},
+				{Name: "build"},
+			}},
+			order: []string{"build", "test", "deploy"},
+		},
+		{
+			name: "unknown dependency",
+			workflow: Workflow{Name: "wf", Steps: []Step{
+				{Name: "aa", Needs: []string{"missing"}},
+			}},
+			err: "step 'aa' of workflow 'wf' needs unknown step 'missing'",
+		},
+		{
+			name: "cycle",
+			workflow: Workflow{Name: "wf", Steps: []Step{
+				{Name: "aa", Needs: []string{"cc"}},
+				{Name: "bb", Needs: []string{"aa"}},
+				{Name: "cc", Needs: []string{"bb"}},
+			}},
+			err: "workflow 'wf' has a dependency cycle: aa -> cc -> bb -> aa",
+		},
+		{
+			name: "step depending on itself",
+			workflow: Workflow{Name: "wf", Steps: []Step{
+				{Name: "aa", Needs: []string{"aa"}},
+			}},
+			err: "workflow 'wf' has a dependency cycle: aa -> aa",
+		},
+		{
+			name: "duplicate step names across hooks",
+			workflow: Workflow{Name: "wf",
+				Steps:   []Step{{Name: "aa"}},
+				Finally: []Step{{Name: "aa"}},
+			},
+			err: "workflow 'wf' has more than one step named 'aa'",
+		},
+		{
+			name: "hooks can't have needs",
+			workflow: Workflow{Name: "wf",
+				Steps:     []Step{{Name: "aa"}},
+				OnFailure: []Step{{Name: "bb", Needs: []string{"aa"}}},
+			},
+			err: "step 'bb' of workflow 'wf' can't have `needs`",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := tt.workflow.ResolveStepGraph()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got := strings.Join(stepNames(order), ","); got != strings.Join(tt.order, ",") {
+				t.Errorf("expected order %v, got %s", tt.order, got)
+			}
+		})
+	}
+}
+
+func TestCheckWorkflowRecursion(t *testing.T) {
+	tests := []struct {
+		name      string
+		workflows []*Workflow
+		err       string
+	}{
+		{
+			name: "nested workflows without recursion",
+			workflows: []*Workflow{
+				{Name: "outer", Steps: []Step{{Name: "aa", RunWorkflow: "inner"}, {Name: "bb", RunWorkflow: "inner"}}},
+				{Name: "inner", Steps: []Step{{Name: "cc", Block: "b", Action: "a"}}},
+			},
+		},
+		{
+			name: "workflow running itself",
+			workflows: []*Workflow{
+				{Name: "self", Steps: []Step{{Name: "aa", RunWorkflow: "self"}}},
+			},
+			err: "workflow 'self' runs itself: self -> self",
+		},
+		{
+			name: "indirect recursion through a finally step",
+			workflows: []*Workflow{
+				{Name: "one", Steps: []Step{{Name: "aa", RunWorkflow: "two"}}},
+				{Name: "two", Steps: []Step{{Name: "bb", Block: "b", Action: "a"}}, Finally: []Step{{Name: "cc", RunWorkflow: "three"}}},
+				{Name: "three", Steps: []Step{{Name: "dd", RunWorkflow: "one"}}},
+			},
+			err: "workflow 'one' runs itself: one -> two -> three -> one",
+		},
+		{
+			name: "step naming its own workflow",
+			workflows: []*Workflow{
+				{Name: "self", Steps: []Step{{Name: "aa", Workflow: "self", Block: "b", Action: "a"}}},
+			},
+		},
+		{
+			name: "unknown nested workflow",
+			workflows: []*Workflow{
+				{Name: "outer", Steps: []Step{{Name: "aa", RunWorkflow: "missing"}}},
+			},
+			err: "step 'aa' of workflow 'outer' runs unknown workflow 'missing'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &Workspace{Workflows: tt.workflows}
+
+			err := w.checkWorkflowRecursion(tt.workflows[0], []string{}, map[string]bool{})
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestResolveWorkflows(t *testing.T) {
+	tx := newTestTransaction()
+	defer tx.CancelFunc()
+
+	w := &Workspace{Workflows: []*Workflow{
+		{Name: "deploy", Steps: []Step{
+			// Workflow files may still name the workflow of a step
+			{Name: "aa", Block: "b", Action: "a", Workflow: "deploy"},
+			{Name: "bb", RunWorkflow: "cleanup"},
+		}},
+		{Name: "cleanup", Steps: []Step{{Name: "cc", Block: "b", Action: "c"}}},
+	}}
+	if err := w.ResolveWorkflows(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, workflow := range w.Workflows {
+		for _, step := range workflow.getAllSteps() {
+			if step.Workflow != workflow.Name || step.workflow != workflow {
+				t.Errorf("expected step '%s' to belong to workflow '%s', got '%s'", step.Name, workflow.Name, step.Workflow)
+			}
+		}
+	}
+
+	// A step runs either an action or a workflow
+	w = &Workspace{Workflows: []*Workflow{
+		{Name: "deploy", Steps: []Step{{Name: "aa", Block: "b", Action: "a", RunWorkflow: "cleanup"}}},
+		{Name: "cleanup", Steps: []Step{{Name: "cc", Block: "b", Action: "c"}}},
+	}}
+	if err := w.ResolveWorkflows(tx); err == nil {
+		t.Errorf("expected a validation error")
+	}
+}
+
+func TestStepMatrixValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		matrix StepMatrix
+		err    bool
+	}{
+		{name: "labels", matrix: StepMatrix{Labels: map[string]string{"tier": "web"}}},
+		{name: "from", matrix: StepMatrix{From: "k8s-app"}},
+		{name: "empty matrix", matrix: StepMatrix{}, err: true},
+		{name: "empty labels", matrix: StepMatrix{Labels: map[string]string{}}, err: true},
+		{name: "label without value", matrix: StepMatrix{Labels: map[string]string{"tier": ""}}, err: true},
+		{name: "label without name", matrix: StepMatrix{Labels: map[string]string{"": "web"}}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step := &Step{Name: "aa", Action: "deploy", Matrix: &tt.matrix}
+			if err := step.validate(); (err != nil) != tt.err {
+				t.Errorf("expected error=%t, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestStepApplyRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		step     Step
+		expected Action
+	}{
+		{
+			name:     "step without policy keeps the policy of the action",
+			step:     Step{Action: "deploy"},
+			expected: Action{Retries: 1, RetryDelay: "5s", Timeout: "1m"},
+		},
+		{
+			name:     "step policy replaces the policy of the action",
+			step:     Step{Action: "deploy", Retries: 3},
+			expected: Action{Retries: 3},
+		},
+		{
+			name:     "step timeout replaces the policy of the action",
+			step:     Step{Action: "deploy", Timeout: "10m"},
+			expected: Action{Timeout: "10m"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action := newTestAction()
+			action.Retries = 1
+			action.RetryDelay = "5s"
+			action.Timeout = "1m"
+			workspace := action.block.workspace
+			workspace.Blocks = []*Block{action.block}
+
+			tt.step.applyRetryPolicy(workspace, "app")
+
+			if action.Retries != tt.expected.Retries || action.RetryDelay != tt.expected.RetryDelay || action.Timeout != tt.expected.Timeout {
+				t.Errorf("expected policy %d/%q/%q, got %d/%q/%q", tt.expected.Retries, tt.expected.RetryDelay, tt.expected.Timeout, action.Retries, action.RetryDelay, action.Timeout)
+			}
+		})
+	}
+}
+
+func TestCheckStepDependencies(t *testing.T) {
+	workflow := &Workflow{Name: "wf", Steps: []Step{
+		{Name: "aa"},
+		{Name: "bb"},
+		{Name: "cc", Needs: []string{"aa", "bb"}},
+	}}
+
+	tests := []struct {
+		name    string
+		results map[string]*StepResult
+		ready   bool
+		skip    bool
+	}{
+		{
+			name: "all dependencies succeeded",
+			results: map[string]*StepResult{
+				"aa": {Status: StepStatusSucceeded},
+				"bb": {Status: StepStatusSucceeded},
+			},
+			ready: true,
+		},
+		{
+			name: "dependency still running",
+			results: map[string]*StepResult{
+				"aa": {Status: StepStatusSucceeded},
+				"bb": {Status: StepStatusRunning},
+			},
+		},
+		{
+			name: "dependency failed",
+			results: map[string]*StepResult{
+				"aa": {Status: StepStatusFailed},
+				"bb": {Status: StepStatusSucceeded},
+			},
+			skip: true,
+		},
+		{
+			name: "dependency failed with allow_failure",
+			results: map[string]*StepResult{
+				"aa": {Status: StepStatusFailed, AllowedFailure: true},
+				"bb": {Status: StepStatusSucceeded},
+			},
+			ready: true,
+		},
+		{
+			name: "dependency skipped by its condition",
+			results: map[string]*StepResult{
+				"aa": {Status: StepStatusSkipped, ConditionNotMet: true},
+				"bb": {Status: StepStatusSucceeded},
+			},
+			ready: true,
+		},
+		{
+			name: "dependency skipped after a failure",
+			results: map[string]*StepResult{
+				"aa": {Status: StepStatusSkipped},
+				"bb": {Status: StepStatusPending},
+			},
+			skip: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ready, skip := workflow.checkStepDependencies(&workflow.Steps[2], tt.results)
+			if ready != tt.ready || skip != tt.skip {
+				t.Errorf("expected ready=%t skip=%t, got ready=%t skip=%t", tt.ready, tt.skip, ready, skip)
+			}
+		})
+	}
+}
+
+func TestWorkflowRunSave(t *testing.T) {
+	tx := newTestTransaction()
+	defer tx.CancelFunc()
+
+	w := &Workspace{Name: "ws", LocalPath: t.TempDir()}
+	w.Config.LogsRoot = "logs"
+
+	root := &WorkflowRun{Transaction: "root", Workflow: "rollout", workspace: w}
+	nested := &WorkflowRun{Transaction: "nested", Workflow: "deploy", workspace: w, parent: root}
+	innermost := &WorkflowRun{Transaction: "innermost", Workflow: "db", workspace: w, parent: nested}
+
+	for _, run := range []*WorkflowRun{root, nested, innermost} {
+		if err := run.Save(tx); err != nil {
+			t.Fatal(err)
+		}
+		if run.getRoot() != root {
+			t.Errorf("expected the root of run %s to be %s, got %s", run.Transaction, root.Transaction, run.getRoot().Transaction)
+		}
+	}
+
+	// Only the top-level run is persisted
+	if _, err := os.Stat(w.getWorkflowRunPath("root")); err != nil {
+		t.Errorf("expected the top-level run to be saved: %s", err)
+	}
+	for _, txid := range []string{"nested", "innermost"} {
+		if _, err := os.Stat(w.getWorkflowRunPath(txid)); !os.IsNotExist(err) {
+			t.Errorf("expected nested run %s not to be saved, got %v", txid, err)
+		}
+	}
+}
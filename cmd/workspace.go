@@ -27,6 +27,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
@@ -34,7 +35,6 @@ import (
 
 	//"github.com/docker/docker/container"
 	"github.com/google/uuid"
-	"github.com/gosimple/hashdir"
 
 	"github.com/go-playground/validator/v10"
 	log "github.com/sirupsen/logrus"
@@ -42,6 +42,7 @@ import (
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"github.com/xlab/treeprint"
+	"golang.org/x/crypto/ssh"
 	"gopkg.in/yaml.v2"
 )
 
@@ -94,16 +95,23 @@ type WorkspaceConfig struct {
 	BlocksRoot string      `yaml:"blocksroot" mapstructure:"blocksroot" json:"blocksroot" validate:"required"`
 	LogsRoot   string      `yaml:"logsroot" mapstructure:"logsroot" json:"logsroot" validate:"required"`
 	// The block configuration file (default: block.poly)
-	BlocksConfig    string                 `yaml:"blocksconfig" mapstructure:"blocksconfig" json:"blocksconfig" validate:"required"`
-	WorkspaceConfig string                 `yaml:"workspaceconfig" mapstructure:"workspaceconfig" json:"workspaceconfig" validate:"required"`
-	WorkflowsRoot   string                 `yaml:"workflowsroot" mapstructure:"workflowsroot" json:"workflowsroot" validate:"required"`
-	ArtifactsRoot   string                 `yaml:"artifactsroot" mapstructure:"artifactsroot" json:"artifactsroot" validate:"required"`
-	ContainerRoot   string                 `yaml:"containerroot" mapstructure:"containerroot" json:"containerroot" validate:"required"`
-	SshPrivateKey   string                 `yaml:"sshprivatekey" mapstructure:"sshprivatekey" json:"sshprivatekey" validate:"required"`
-	SshPublicKey    string                 `yaml:"sshpublickey" mapstructure:"sshpublickey" json:"sshpublickey" validate:"required"`
-	RemoteRoot      string                 `yaml:"remoteroot" mapstructure:"remoteroot" json:"remoteroot" validate:"required"`
-	Dockerfile      string                 `yaml:"dockerfile" mapstructure:"dockerfile,omitempty" json:"dockerfile,omitempty"`
-	Globals         map[string]interface{} `yaml:"globals" mapstructure:"globals" json:"globals"`
+	BlocksConfig    string `yaml:"blocksconfig" mapstructure:"blocksconfig" json:"blocksconfig" validate:"required"`
+	WorkspaceConfig string `yaml:"workspaceconfig" mapstructure:"workspaceconfig" json:"workspaceconfig" validate:"required"`
+	WorkflowsRoot   string `yaml:"workflowsroot" mapstructure:"workflowsroot" json:"workflowsroot" validate:"required"`
+	ArtifactsRoot   string `yaml:"artifactsroot" mapstructure:"artifactsroot" json:"artifactsroot" validate:"required"`
+	ContainerRoot   string `yaml:"containerroot" mapstructure:"containerroot" json:"containerroot" validate:"required"`
+	SshPrivateKey   string `yaml:"sshprivatekey" mapstructure:"sshprivatekey" json:"sshprivatekey" validate:"required"`
+	SshPublicKey    string `yaml:"sshpublickey" mapstructure:"sshpublickey" json:"sshpublickey" validate:"required"`
+	RemoteRoot      string `yaml:"remoteroot" mapstructure:"remoteroot" json:"remoteroot" validate:"required"`
+	Dockerfile      string `yaml:"dockerfile" mapstructure:"dockerfile,omitempty" json:"dockerfile,omitempty"`
+	// What happens if a pulled block has been modified: `warn` (default), `fail` or `off`
+	VerifyBlocks string `yaml:"verifyblocks,omitempty" mapstructure:"verifyblocks,omitempty" json:"verifyblocks,omitempty" validate:"omitempty,oneof=warn fail off"`
+	// Scope of the lock held while an action runs: one per `block` (default) or one for the whole `workspace`
+	Lock string `yaml:"lock,omitempty" mapstructure:"lock,omitempty" json:"lock,omitempty" validate:"omitempty,oneof=block workspace"`
+	// Keys trusted to sign pulled blocks in addition to the globally trusted keys (unless the global mode is enforce)
+	// The mode can only be stricter than the global mode
+	Trust   TrustPolicy            `yaml:"trust,omitempty" mapstructure:"trust,omitempty" json:"trust,omitempty"`
+	Globals map[string]interface{} `yaml:"globals" mapstructure:"globals" json:"globals"`
 }
 type WorkspaceEventConfig struct {
 	Handler  string `yaml:"handler" mapstructure:"handler" json:"handler" validate:"required"`
@@ -165,6 +173,10 @@ type Workspace struct {
 	currentAction   *Action
 	currentWorkflow *Workflow
 	currentStep     *Step
+	stepResults     map[string]*StepResult
+	workflowFailure WorkflowFailure
+	inputs          map[string]string
+	currentInputs   map[string]interface{}
 	revision        *WorkspaceRevision
 	env             map[string]string
 	mounts          map[string]string
@@ -192,6 +204,12 @@ type WorkspaceSnapshot struct {
 	Step      *Step             `yaml:"step,omitempty" mapstructure:"step,omitempty" json:"step,omitempty"`
 	Env       map[string]string `yaml:"env,omitempty" mapstructure:"env,omitempty" json:"env,omitempty"`
 	Mounts    map[string]string `yaml:"mounts,omitempty" mapstructure:"mounts,omitempty" json:"mounts,omitempty"`
+	// Results of the steps of the current workflow run, keyed by step name
+	Steps map[string]*StepResult `yaml:"steps,omitempty" mapstructure:"steps,omitempty" json:"steps,omitempty"`
+	// The step that made the current workflow run fail
+	Failure WorkflowFailure `yaml:"failure,omitempty" mapstructure:"failure,omitempty" json:"failure,omitempty"`
+	// The resolved inputs of the current action
+	Inputs map[string]interface{} `yaml:"inputs,omitempty" mapstructure:"inputs,omitempty" json:"inputs,omitempty"`
 }
 
 func (w *Workspace) CreateSshKeys(ctx context.Context) error {
@@ -332,6 +350,214 @@ func (w *Workspace) RunAction(tx *PolycrateTransaction, _block string, _action s
 		return err
 	}
 
+	// If --snapshot is set, only the snapshot of the action itself is printed
+	actions := []*Action{action}
+	if !snapshot {
+		actions, err = w.ResolveActionDependencies(action)
+		if err != nil {
+			return err
+		}
+	}
+
+	dependencies := w.getDependencies(actions)
+
+	inputs := w.inputs
+	defer w.SetInputs(inputs)
+
+	var outputs map[string]string
+	for _, a := range actions {
+		if a == action {
+			w.SetInputs(inputs)
+		} else {
+			// --input only applies to the action that has been requested
+			w.SetInputs(map[string]string{})
+			tx.Log.Infof("Running action %s:%s for %s:%s", a.Block, a.Name, action.Block, action.Name)
+		}
+
+		// The requested action runs even if a post hook depends on it
+		err := w.runAction(tx, a, a != action && dependencies[a])
+		if errors.Is(err, ErrConditionNotMet) && a != action {
+			// Skipped dependencies and hooks don't stop the other actions
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if a == action {
+			outputs = tx.Outputs
+		}
+	}
+
+	// The outputs of post hooks don't replace the outputs of the action
+	tx.SetOutputs(outputs)
+
+	return nil
+}
+
+// Returns the actions to run for the action in order: its dependencies, its pre hooks,
+// the action itself and its post hooks. Every action is returned only once
+// Dependencies are skipped when they run if they are up to date, hooks run every time
+func (w *Workspace) ResolveActionDependencies(action *Action) ([]*Action, error) {
+	actions := []*Action{}
+	if err := w.resolveActionDependencies(action, []string{}, map[*Action]bool{}, &actions); err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
+
+func (w *Workspace) resolveActionDependencies(action *Action, path []string, resolved map[*Action]bool, actions *[]*Action) error {
+	address := action.getAddress()
+	for i, name := range path {
+		if name == address {
+			cycle := append(path[i:], address)
+			return fmt.Errorf("action '%s' depends on itself: %s", address, strings.Join(cycle, " -> "))
+		}
+	}
+	if resolved[action] {
+		return nil
+	}
+
+	path = append(path, address)
+	before := append(append([]string{}, action.DependsOn...), action.Pre...)
+	for _, ref := range before {
+		dependency, err := w.getActionByRef(action, ref)
+		if err != nil {
+			return err
+		}
+		if err := w.resolveActionDependencies(dependency, path, resolved, actions); err != nil {
+			return err
+		}
+	}
+
+	resolved[action] = true
+	*actions = append(*actions, action)
+
+	// Post hooks run after the action, the action doesn't depend on them
+	path = path[:len(path)-1]
+	for _, ref := range action.Post {
+		hook, err := w.getActionByRef(action, ref)
+		if err != nil {
+			return err
+		}
+		if err := w.resolveActionDependencies(hook, path, resolved, actions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the actions that are a dependency (depends_on) of another of the given actions
+func (w *Workspace) getDependencies(actions []*Action) map[*Action]bool {
+	dependencies := map[*Action]bool{}
+	for _, action := range actions {
+		for _, ref := range action.DependsOn {
+			if dependency, err := w.getActionByRef(action, ref); err == nil {
+				dependencies[dependency] = true
+			}
+		}
+	}
+	return dependencies
+}
+
+// Returns the action a reference of another action points to.
+// References are either `block:action` or `action` for an action of the same block
+func (w *Workspace) getActionByRef(action *Action, ref string) (*Action, error) {
+	blockName, actionName := action.Block, ref
+	if p := strings.SplitN(ref, ":", 2); len(p) == 2 {
+		blockName, actionName = p[0], p[1]
+	}
+
+	block, err := w.GetBlock(blockName)
+	if err != nil {
+		return nil, fmt.Errorf("action '%s' of block '%s' refers to unknown block '%s'", action.Name, action.Block, blockName)
+	}
+	referenced, err := block.GetAction(actionName)
+	if err != nil {
+		return nil, fmt.Errorf("action '%s' of block '%s' refers to unknown action '%s'", action.Name, action.Block, ref)
+	}
+	return referenced, nil
+}
+
+// The last successful run of an action
+// It's saved in its own file so unchanged actions are skipped even if events aren't saved to the workspace logs
+type ActionCache struct {
+	Key         string            `yaml:"key,omitempty" mapstructure:"key,omitempty" json:"key,omitempty"`
+	Transaction string            `yaml:"transaction,omitempty" mapstructure:"transaction,omitempty" json:"transaction,omitempty"`
+	Date        string            `yaml:"date,omitempty" mapstructure:"date,omitempty" json:"date,omitempty"`
+	Outputs     map[string]string `yaml:"outputs,omitempty" mapstructure:"outputs,omitempty" json:"outputs,omitempty"`
+}
+
+func (w *Workspace) getActionCachePath(action *Action) string {
+	return filepath.Join(w.LocalPath, w.Config.LogsRoot, WorkspaceCacheDir, action.Block, strings.Join([]string{action.Name, "yml"}, "."))
+}
+
+// Returns the last successful run of the action
+// Runs recorded before the cache file existed are looked up in the workspace logs
+func (w *Workspace) getLastCachedRun(action *Action) *ActionCache {
+	cache := &ActionCache{}
+	if _, err := loadYAMLFile(w.getActionCachePath(action), cache); err == nil {
+		return cache
+	}
+
+	address := action.getAddress()
+
+	var last *WorkspaceLog
+	for _, log := range w.logs {
+		if _, ok := log.CacheKeys[address]; !ok {
+			continue
+		}
+		if last == nil || log.Date > last.Date {
+			last = log
+		}
+	}
+	if last == nil {
+		return nil
+	}
+
+	cache = &ActionCache{
+		Key:         last.CacheKeys[address],
+		Transaction: last.Transaction,
+		Date:        last.Date,
+	}
+	// The outputs are only those of the action if it has been the action of the transaction
+	if last.Block == action.Block && last.Action == action.Name {
+		cache.Outputs = last.Outputs
+	}
+	return cache
+}
+
+// Records a successful run of the action in the transaction and in the cache file of the action
+func (w *Workspace) saveCachedRun(tx *PolycrateTransaction, action *Action, key string) {
+	tx.SetCacheKey(action.getAddress(), key)
+
+	cache := ActionCache{
+		Key:         key,
+		Transaction: tx.TXID.String(),
+		Date:        time.Now().Format(time.RFC3339),
+		Outputs:     tx.Outputs,
+	}
+
+	path := w.getActionCachePath(action)
+	tx.Log.Debugf("Saving cache key of action at %s", path)
+
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err == nil {
+		var data []byte
+		if data, err = yaml.Marshal(cache); err == nil {
+			err = os.WriteFile(path, data, 0644)
+		}
+	}
+	if err != nil {
+		tx.Log.Warnf("Failed to save cache key of action: %s", err)
+	}
+}
+
+// Runs a single action without its dependencies and hooks
+// A dependency of another action is skipped if it's up to date, even if it isn't idempotent
+func (w *Workspace) runAction(tx *PolycrateTransaction, action *Action, dependency bool) error {
+	block := action.block
+
 	if block.Template {
 		return errors.New("this is a template block. not running action")
 	}
@@ -352,14 +578,23 @@ func (w *Workspace) RunAction(tx *PolycrateTransaction, _block string, _action s
 	if snapshot {
 		w.Snapshot()
 	} else {
-		err := action.Run(tx)
+		// Actions of the same block must not run at the same time
+		lock, err := w.AcquireLock(tx, action)
+		if err != nil {
+			return err
+		}
+
+		err = action.Run(tx, dependency)
+		if releaseErr := w.ReleaseLock(tx, lock); releaseErr != nil {
+			tx.Log.Warnf("Failed to release lock '%s': %s", lock.Name, releaseErr)
+		}
 		if err != nil {
 			return err
 		}
 	}
 
 	// Reload Block after action execution to update artifacts, inventory and kubeconfig
-	err = block.Reload(tx)
+	err := block.Reload(tx)
 	if err != nil {
 		return err
 	}
@@ -463,32 +698,11 @@ func (w *Workspace) ResolveBlock(tx *PolycrateTransaction, block *Block, workspa
 			tx.Log.Tracef("Dependency '%s' detected for block '%s'", block.From, block.Name)
 
 			// Try to load the referenced Block
+			// Missing Blocks have been pulled (or reported) by ResolveBlockDependencies
 			dependency, err := w.GetBlock(block.From)
 			if err != nil {
-				// Block does not exist
-				if blocksAutoPull {
-					// Auto-pulling enabled with --blocks-auto-pull
-					// Try to download it
-					tx.Log.Warnf("Block '%s' not found in workspace. Pulling.", block.From)
-					fullTag, registryUrl, blockName, blockVersion := mapDockerTag(block.From)
-
-					dependency, err = w.PullBlock(tx, fullTag, registryUrl, blockName, blockVersion)
-					if err != nil {
-						return err
-					}
-
-					// Append block to block list
-					w.Blocks = append(w.Blocks, dependency)
-
-					err = w.ResolveBlock(tx, dependency, workspaceLocalPath, workspaceContainerPath)
-					if err != nil {
-						return err
-					}
-				} else {
-					err := fmt.Errorf("dependency '%s' not found in the Workspace. Please run `polycrate workspace update`, `polycrate block pull %s` or run Polycrate with the `--blocks-auto-pull` flag", block.From, block.From)
-					return err
-				}
-				//dependency, _ = w.GetBlock(block.From)
+				err := fmt.Errorf("dependency '%s' not found in the Workspace. Please run `polycrate workspace update`, `polycrate block pull %s` or run Polycrate with the `--blocks-auto-pull` flag", block.From, block.From)
+				return err
 			}
 
 			if dependency == nil {
@@ -503,10 +717,10 @@ func (w *Workspace) ResolveBlock(tx *PolycrateTransaction, block *Block, workspa
 			dep := dependency
 
 			// Check if the dependency Block has already been resolved
-			// If not, re-queue the loaded Block so it can be resolved in another iteration
+			// Blocks are resolved in the order of the block graph, so this only happens if the Block is resolved on its own
 			if !dep.resolved {
 				// Needed Block from 'from' stanza is not yet resolved
-				tx.Log.Tracef("Dependency '%s' for block '%s' not yet resolved. Deferring.", block.From, block.Name)
+				tx.Log.Tracef("Dependency '%s' for block '%s' not yet resolved", block.From, block.Name)
 
 				block.resolved = false
 				err := ErrDependencyNotResolved
@@ -701,14 +915,72 @@ func (w *Workspace) PruneContainer(tx *PolycrateTransaction) error {
 	return polycrate.PruneContainer(tx)
 }
 
-func (w *Workspace) RunContainer(tx *PolycrateTransaction, name string, workdir string, cmd []string) error {
+// Returns the image the workspace container is started from
+// With --build, a custom image is built from the Dockerfile of the workspace and tagged as <workspace>:<version>
+// An image or dockerfile in the container config of an action or block takes precedence;
+// the image of a dockerfile is tagged as <workspace>-<context>:<version>
+func (w *Workspace) getContainerImage(config ContainerConfig) string {
+	if config.Image != "" {
+		return config.Image
+	}
+	if config.Dockerfile != "" {
+		return slugify([]string{w.Name, filepath.Base(config.context)}) + ":" + version
+	}
+	if w.Config.Dockerfile != "" && build {
+		if _, err := os.Stat(filepath.Join(w.LocalPath, w.Config.Dockerfile)); !os.IsNotExist(err) {
+			return w.Name + ":" + version
+		}
+	}
+	return strings.Join([]string{w.Config.Image.Reference, w.Config.Image.Version}, ":")
+}
+
+func (w *Workspace) RunContainer(ctx context.Context, tx *PolycrateTransaction, name string, workdir string, cmd []string, config ContainerConfig) error {
 
 	tx.Log.Debugf("Preparing to start container")
 
-	containerImage := strings.Join([]string{w.Config.Image.Reference, w.Config.Image.Version}, ":")
+	containerImage := w.getContainerImage(config)
+
+	if config.Image != "" {
+		// The action or block brings its own image
+		if pull {
+			err := polycrate.PullImage(ctx, containerImage)
+
+			if err != nil {
+				return err
+			}
+		} else {
+			log.Debugf("Not pulling/building image")
+		}
+	} else if config.Dockerfile != "" {
+		// The action or block brings its own Dockerfile
+		// Its image is built with --build or if it hasn't been built before
+		dockerfilePath := filepath.Join(config.context, config.Dockerfile)
+		if _, err := os.Stat(dockerfilePath); os.IsNotExist(err) {
+			return fmt.Errorf("dockerfile not found: %s", dockerfilePath)
+		}
+
+		exists := false
+		if !build {
+			var err error
+			if exists, err = containerImageExists(ctx, containerImage); err != nil {
+				return err
+			}
+		}
+
+		if exists {
+			tx.Log.Debugf("Using custom image: %s", containerImage)
+		} else {
+			tx.Log.Warnf("Building custom image: %s", containerImage)
 
-	// Check if a Dockerfile is configured in the Workspace
-	if w.Config.Dockerfile != "" {
+			tags := []string{containerImage}
+			var err error
+			containerImage, err = polycrate.BuildContainer(ctx, config.context, config.Dockerfile, tags)
+			if err != nil {
+				return err
+			}
+		}
+	} else if w.Config.Dockerfile != "" {
+		// Check if a Dockerfile is configured in the Workspace
 		// Create the filepath
 		dockerfilePath := filepath.Join(w.LocalPath, w.Config.Dockerfile)
 
@@ -722,13 +994,13 @@ func (w *Workspace) RunContainer(tx *PolycrateTransaction, name string, workdir
 				tx.Log.Warnf("Building custom image: %s", tag)
 
 				tags := []string{tag}
-				containerImage, err = polycrate.BuildContainer(tx.Context, w.LocalPath, w.Config.Dockerfile, tags)
+				containerImage, err = polycrate.BuildContainer(ctx, w.LocalPath, w.Config.Dockerfile, tags)
 				if err != nil {
 					return err
 				}
 			} else {
 				if pull {
-					err := polycrate.PullImage(tx.Context, containerImage)
+					err := polycrate.PullImage(ctx, containerImage)
 
 					if err != nil {
 						return err
@@ -739,7 +1011,7 @@ func (w *Workspace) RunContainer(tx *PolycrateTransaction, name string, workdir
 			}
 		} else {
 			if pull {
-				err := polycrate.PullImage(tx.Context, containerImage)
+				err := polycrate.PullImage(ctx, containerImage)
 
 				if err != nil {
 					return err
@@ -750,7 +1022,7 @@ func (w *Workspace) RunContainer(tx *PolycrateTransaction, name string, workdir
 		}
 	} else {
 		if pull {
-			err := polycrate.PullImage(tx.Context, containerImage)
+			err := polycrate.PullImage(ctx, containerImage)
 
 			if err != nil {
 				return err
@@ -768,6 +1040,7 @@ func (w *Workspace) RunContainer(tx *PolycrateTransaction, name string, workdir
 		m := strings.Join([]string{mount, w.mounts[mount]}, ":")
 		mounts = append(mounts, m)
 	}
+	mounts = append(mounts, config.Mounts...)
 
 	// Setup labels
 	labels := []string{}
@@ -782,6 +1055,7 @@ func (w *Workspace) RunContainer(tx *PolycrateTransaction, name string, workdir
 	containerName := tx.TXID.String()
 
 	exitCode, output, err := polycrate.RunContainer(
+		ctx,
 		tx,
 		mounts,
 		env,
@@ -791,6 +1065,7 @@ func (w *Workspace) RunContainer(tx *PolycrateTransaction, name string, workdir
 		workdir,
 		containerImage,
 		runCommand,
+		config,
 	)
 
 	// Save output and exit code to transaction metadata
@@ -834,6 +1109,78 @@ func (w *Workspace) RunWorkflow(tx *PolycrateTransaction, name string, stepName
 	return nil
 }
 
+// Prints what the steps of a workflow would run without running them
+func (w *Workspace) PlanWorkflow(tx *PolycrateTransaction, name string, format string) error {
+	workflow, err := w.GetWorkflow(name)
+	if err != nil {
+		return err
+	}
+
+	plan, err := workflow.Plan(tx)
+	if err != nil {
+		return err
+	}
+
+	return plan.Print(format)
+}
+
+// Resumes a previous run of a workflow, identified by the TXID of the transaction that started it
+func (w *Workspace) ResumeWorkflow(tx *PolycrateTransaction, txid string) error {
+	run, err := w.LoadWorkflowRun(tx, txid)
+	if err != nil {
+		return err
+	}
+
+	var workflow *Workflow
+	workflow, err = w.GetWorkflow(run.Workflow)
+	if err != nil {
+		return err
+	}
+
+	// Use the same additional env vars and mounts as the original run
+	// Env vars and mounts given to the resuming command take precedence
+	// The steps run in copies of this workspace, so they inherit them
+	extraEnv := append(append([]string{}, run.Env...), w.ExtraEnv...)
+	extraMounts := append(append([]string{}, run.Mounts...), w.ExtraMounts...)
+	if err := w.registerExtraEnv(extraEnv); err != nil {
+		return err
+	}
+	if err := w.registerExtraMounts(extraMounts); err != nil {
+		return err
+	}
+	w.ExtraEnv = extraEnv
+	w.ExtraMounts = extraMounts
+
+	w.registerCurrentWorkflow(workflow)
+
+	return workflow.Resume(tx, run)
+}
+
+func (w *Workspace) getWorkflowRunPath(txid string) string {
+	return filepath.Join(w.LocalPath, w.Config.LogsRoot, WorkspaceWorkflowRunsDir, strings.Join([]string{txid, "yml"}, "."))
+}
+
+func (w *Workspace) LoadWorkflowRun(tx *PolycrateTransaction, txid string) (*WorkflowRun, error) {
+	path := w.getWorkflowRunPath(txid)
+	tx.Log.Debugf("Loading workflow run from %s", path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("workflow run not found: %s", txid)
+		}
+		return nil, err
+	}
+
+	run := new(WorkflowRun)
+	if err := yaml.Unmarshal(data, run); err != nil {
+		return nil, err
+	}
+	run.workspace = w
+
+	return run, nil
+}
+
 // func (w *Workspace) RunWorkflow(ctx context.Context, name string) error {
 
 // 	// Find workflow in index
@@ -1198,12 +1545,148 @@ func (w *Workspace) Reload(tx *PolycrateTransaction, validate bool) (*Workspace,
 	return w.Load(tx, w.LocalPath, validate)
 }
 
+// Loads an independent copy of the workspace from disk
+// Used for runs that must see changes to the workspace made after it has been loaded (e.g. scheduled runs)
+func (w *Workspace) LoadClone(tx *PolycrateTransaction) (*Workspace, error) {
+	tx.Log.Debug("Loading copy of workspace")
+
+	clone := new(Workspace)
+
+	// Make a hard copy of the defaultWorkspace
+	*clone = defaultWorkspace
+	clone.LocalPath = w.LocalPath
+
+	return clone.Load(tx, w.LocalPath, true)
+}
+
+// Returns an independent copy of the loaded workspace
+// The copy has its own blocks, actions, workflows, env, mounts and current block/action, so it can be
+// used to run workflow steps in parallel. Only the artifacts of the blocks are looked up again,
+// as earlier steps might have created them (e.g. a kubeconfig)
+// The given step results and workflow failure are available to templates and conditions
+// as `.Steps` and `.Failure`
+func (w *Workspace) Clone(tx *PolycrateTransaction, stepResults map[string]*StepResult, failure WorkflowFailure) (*Workspace, error) {
+	tx.Log.Debug("Cloning workspace")
+
+	clone := new(Workspace)
+	*clone = *w
+	clone.stepResults = stepResults
+	clone.workflowFailure = failure
+	clone.runtimeDir = filepath.Join(polycrateRuntimeDir, tx.TXID.String(), w.Name)
+
+	clone.env = map[string]string{}
+	for key, value := range w.env {
+		clone.env[key] = value
+	}
+	clone.mounts = map[string]string{}
+	for host, container := range w.mounts {
+		clone.mounts[host] = container
+	}
+	clone.inputs = nil
+	clone.currentInputs = nil
+
+	if w.revision != nil {
+		revision := *w.revision
+		revision.Transaction = tx.TXID
+		clone.revision = &revision
+	}
+
+	blocks := map[*Block]*Block{}
+	actions := map[*Action]*Action{}
+	clone.Blocks = make([]*Block, len(w.Blocks))
+	for i, block := range w.Blocks {
+		_block := *block
+		_block.workspace = clone
+		_block.Actions = make([]*Action, len(block.Actions))
+		for j, action := range block.Actions {
+			_action := *action
+			_action.workspace = clone
+			_action.block = &_block
+			_block.Actions[j] = &_action
+			actions[action] = &_action
+		}
+		clone.Blocks[i] = &_block
+		blocks[block] = &_block
+	}
+
+	clone.installedBlocks = make([]*Block, 0, len(w.installedBlocks))
+	for _, block := range w.installedBlocks {
+		if _block, ok := blocks[block]; ok {
+			clone.installedBlocks = append(clone.installedBlocks, _block)
+		}
+	}
+
+	steps := map[*Step]*Step{}
+	workflows := map[*Workflow]*Workflow{}
+	copySteps := func(workflow *Workflow, source []Step) []Step {
+		if source == nil {
+			return nil
+		}
+		_steps := make([]Step, len(source))
+		for i := range source {
+			_steps[i] = source[i]
+			_steps[i].workflow = workflow
+			_steps[i].matrixResults = nil
+			_steps[i].workflowResults = nil
+			steps[&source[i]] = &_steps[i]
+		}
+		return _steps
+	}
+	clone.Workflows = make([]*Workflow, len(w.Workflows))
+	for i, workflow := range w.Workflows {
+		_workflow := *workflow
+		_workflow.workspace = clone
+		_workflow.Steps = copySteps(&_workflow, workflow.Steps)
+		_workflow.OnFailure = copySteps(&_workflow, workflow.OnFailure)
+		_workflow.Finally = copySteps(&_workflow, workflow.Finally)
+		clone.Workflows[i] = &_workflow
+		workflows[workflow] = &_workflow
+	}
+
+	clone.currentBlock = blocks[w.currentBlock]
+	clone.currentAction = actions[w.currentAction]
+	clone.currentWorkflow = workflows[w.currentWorkflow]
+	clone.currentStep = steps[w.currentStep]
+
+	// Pick up artifacts that have been created since the workspace has been loaded
+	if err := clone.LoadInventory(tx); err != nil {
+		return nil, err
+	}
+	if err := clone.LoadKubeconfig(tx); err != nil {
+		return nil, err
+	}
+	for _, block := range clone.Blocks {
+		if !block.resolved {
+			continue
+		}
+		if err := block.LoadInventory(tx); err != nil {
+			return nil, err
+		}
+		if err := block.LoadKubeconfig(tx); err != nil {
+			return nil, err
+		}
+	}
+	clone.registerEnvVar("KUBECONFIG", clone.Kubeconfig.Path)
+	clone.registerEnvVar("POLYCRATE_OUTPUT", clone.getOutputPath())
+
+	// Render the scripts with the step results of the copy
+	if err := clone.templateActionScripts(); err != nil {
+		return nil, err
+	}
+
+	return clone, nil
+}
+
 func (w *Workspace) Preload(tx *PolycrateTransaction, path string, validate bool) (*Workspace, error) {
 	var err error
 
 	// Reset blocks
 	w.Blocks = []*Block{}
 
+	// Reset workflows
+	// Otherwise workflows loaded from the workflows root would pile up when reloading the workspace
+	w.Workflows = []*Workflow{}
+
 	// Check if this is a git repo
 	w.isGitRepo = GitIsRepo(path)
 
@@ -1213,6 +1696,14 @@ func (w *Workspace) Preload(tx *PolycrateTransaction, path string, validate bool
 		return nil, err
 	}
 
+	// Find all workflows in the workflows root
+	tx.Log.Debug("Searching for workflows")
+
+	workflowsDir := filepath.Join(w.LocalPath, w.Config.WorkflowsRoot)
+	if err := w.FindWorkflows(tx, workflowsDir); err != nil {
+		return nil, err
+	}
+
 	// Setup Logger
 	tx.Log.SetField("workspace", w.Name)
 
@@ -1716,51 +2207,299 @@ func (c *Workspace) Uninstall() error {
 }
 
 // Resolves the 'from:' stanza of all blocks
+// Resolves the Blocks of the Workspace along the block graph, so every Block is resolved after the Block it inherits from
 func (w *Workspace) ResolveBlockDependencies(tx *PolycrateTransaction) error {
+	if blocksAutoPull {
+		if err := w.pullMissingBlocks(tx); err != nil {
+			return err
+		}
+	}
+
+	order, err := w.ResolveBlockGraph()
+	if err != nil {
+		return err
+	}
 
-	missing := len(w.Blocks)
+	for _, block := range order {
+		log.Tracef("Resolving block '%s' - resolved? %t", block.Name, block.resolved)
 
-	// Iterate over all Blocks in the Workspace
-	// Until nothing is "missing" anymore
-	for missing > 0 {
-		tx.Log.Debugf("Unresolved blocks: %d", missing)
-		for i := 0; i < len(w.Blocks); i++ {
-			loadedBlock := w.Blocks[i]
+		if err := w.ResolveBlock(tx, block, w.LocalPath, w.ContainerPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-			log.Tracef("Resolving block '%s' - resolved? %t", loadedBlock.Name, loadedBlock.resolved)
+// Pulls the Blocks referenced in a "from:" stanza that are missing from the Workspace (--blocks-auto-pull)
+// Pulled Blocks are checked as well, as they might inherit from missing Blocks themselves
+// Versions and digests are taken from workspace.lock; blocks that aren't locked yet are resolved and added to it
+func (w *Workspace) pullMissingBlocks(tx *PolycrateTransaction) error {
+	lockfile, err := w.LoadLockfile()
+	if err != nil {
+		return err
+	}
+	updated := false
 
-			if !loadedBlock.resolved {
-				err := w.ResolveBlock(tx, loadedBlock, w.LocalPath, w.ContainerPath)
+	for i := 0; i < len(w.Blocks); i++ {
+		block := w.Blocks[i]
+		if block.From == "" {
+			continue
+		}
+		if _, err := w.GetBlock(block.From); err == nil {
+			continue
+		}
 
+		tx.Log.Warnf("Block '%s' not found in workspace. Pulling.", block.From)
+		reference, digest := splitDigest(block.From)
+		_, registryUrl, blockName, constraint := mapDockerTag(reference)
+		repository := strings.Join([]string{registryUrl, blockName}, "/")
+
+		version := constraint
+		if digest == "" {
+			if entry := lockfile.getBlock(repository); entry != nil && entry.Constraint == constraint {
+				tx.Log.Debugf("Using locked version %s of block '%s'", entry.Version, repository)
+				version = entry.Version
+				digest = entry.Digest
+			} else {
+				version, err = resolveBlockVersion(repository, constraint)
 				if err != nil {
-					switch {
-					case errors.Is(err, ErrDependencyNotResolved):
-						loadedBlock.resolved = false
-						continue
-					default:
-						return err
-					}
+					return err
+				}
+				tx.Log.Debugf("Resolved block '%s:%s' to version %s", repository, constraint, version)
+
+				digest, err = GetOCIDigest(strings.Join([]string{repository, version}, ":"))
+				if err != nil {
+					return err
 				}
+
+				lockfile.setBlock(WorkspaceLockfileBlock{
+					Name:       repository,
+					Constraint: constraint,
+					Version:    version,
+					Digest:     digest,
+				})
+				updated = true
 			}
-			missing--
 		}
+
+		fullTag := strings.Join([]string{repository, version}, ":")
+		dependency, err := w.PullBlock(tx, fullTag, registryUrl, blockName, version, digest)
+		if err != nil {
+			return err
+		}
+
+		// Append block to block list
+		w.Blocks = append(w.Blocks, dependency)
+	}
+
+	if updated {
+		return w.SaveLockfile(tx, lockfile)
 	}
 	return nil
 }
 
-func (w *Workspace) ResolveWorkflows(tx *PolycrateTransaction) error {
-	for i := 0; i < len(w.Workflows); i++ {
-		loadedWorkflow := w.Workflows[i]
+// Returns the Blocks of the Workspace ordered so that every Block comes after the Block it inherits from ("from:")
+// All missing parents are reported at once, cycles with the full chain
+func (w *Workspace) ResolveBlockGraph() ([]*Block, error) {
+	parents := map[*Block]*Block{}
+	missing := []string{}
+	for _, block := range w.Blocks {
+		if block.From == "" {
+			continue
+		}
 
-		loadedWorkflow.workspace = w
+		parent, err := w.GetBlock(block.From)
+		if err != nil {
+			missing = append(missing, fmt.Sprintf("'%s' (from block '%s')", block.From, block.Name))
+			continue
+		}
+		parents[block] = parent
+	}
 
-		// Loop over the steps
-		for _, step := range loadedWorkflow.Steps {
-			loadedStep, err := loadedWorkflow.GetStep(step.Name)
-			if err != nil {
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("dependencies not found in the Workspace: %s. Please run `polycrate workspace update`, `polycrate block pull` or run Polycrate with the `--blocks-auto-pull` flag", strings.Join(missing, ", "))
+	}
+
+	// Depth-first search along "from:", keeping track of the current path to report cycles
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[*Block]int{}
+	path := []string{}
+	order := []*Block{}
+
+	var visit func(block *Block) error
+	visit = func(block *Block) error {
+		switch state[block] {
+		case visited:
+			return nil
+		case visiting:
+			// Cut the path at the first occurrence of the block to get the cycle
+			cycle := []string{}
+			for j, name := range path {
+				if name == block.Name {
+					cycle = append(cycle, path[j:]...)
+					break
+				}
+			}
+			cycle = append(cycle, block.Name)
+			return fmt.Errorf("block '%s' inherits from itself: %s", block.Name, strings.Join(cycle, " -> "))
+		}
+
+		state[block] = visiting
+		path = append(path, block.Name)
+
+		if parent, ok := parents[block]; ok {
+			if err := visit(parent); err != nil {
 				return err
 			}
+		}
+
+		path = path[:len(path)-1]
+		state[block] = visited
+		order = append(order, block)
+		return nil
+	}
+
+	for _, block := range w.Blocks {
+		if err := visit(block); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+// A Block and the Blocks that inherit from it
+type BlockGraphNode struct {
+	Name     string            `yaml:"name" mapstructure:"name" json:"name"`
+	Version  string            `yaml:"version,omitempty" mapstructure:"version,omitempty" json:"version,omitempty"`
+	From     string            `yaml:"from,omitempty" mapstructure:"from,omitempty" json:"from,omitempty"`
+	Template bool              `yaml:"template,omitempty" mapstructure:"template,omitempty" json:"template,omitempty"`
+	Children []*BlockGraphNode `yaml:"children,omitempty" mapstructure:"children,omitempty" json:"children,omitempty"`
+}
+
+// Returns the Blocks that don't inherit from another Block, each with the tree of Blocks inheriting from it
+func (w *Workspace) GetBlockGraph() ([]*BlockGraphNode, error) {
+	order, err := w.ResolveBlockGraph()
+	if err != nil {
+		return nil, err
+	}
+
+	// Parents come first in the order, so their nodes exist when their children are added
+	nodes := map[*Block]*BlockGraphNode{}
+	roots := []*BlockGraphNode{}
+	for _, block := range order {
+		node := &BlockGraphNode{
+			Name:     block.Name,
+			Version:  block.Version,
+			From:     block.From,
+			Template: block.Template,
+		}
+		nodes[block] = node
+
+		if block.From == "" {
+			roots = append(roots, node)
+			continue
+		}
+		parent, _ := w.GetBlock(block.From)
+		nodes[parent].Children = append(nodes[parent].Children, node)
+	}
 
+	var sortNodes func(nodes []*BlockGraphNode)
+	sortNodes = func(nodes []*BlockGraphNode) {
+		sort.Slice(nodes, func(i, j int) bool {
+			return nodes[i].Name < nodes[j].Name
+		})
+		for _, node := range nodes {
+			sortNodes(node.Children)
+		}
+	}
+	sortNodes(roots)
+
+	return roots, nil
+}
+
+// Prints the block graph as a tree, in DOT format or as JSON
+func (w *Workspace) PrintBlockGraph(format string) error {
+	roots, err := w.GetBlockGraph()
+	if err != nil {
+		return err
+	}
+
+	label := func(node *BlockGraphNode) string {
+		if node.Version != "" {
+			return node.Name + ":" + node.Version
+		}
+		return node.Name
+	}
+
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(roots, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", data)
+	case "dot":
+		lines := []string{"digraph blocks {", "  rankdir=LR;"}
+
+		var addNode func(node *BlockGraphNode)
+		addNode = func(node *BlockGraphNode) {
+			style := ""
+			if node.Template {
+				style = ", style=dashed"
+			}
+			lines = append(lines, fmt.Sprintf("  %q [label=%q%s];", node.Name, label(node), style))
+			for _, child := range node.Children {
+				lines = append(lines, fmt.Sprintf("  %q -> %q;", node.Name, child.Name))
+				addNode(child)
+			}
+		}
+		for _, root := range roots {
+			addNode(root)
+		}
+
+		lines = append(lines, "}")
+		fmt.Println(strings.Join(lines, "\n"))
+	case "tree":
+		tree := treeprint.NewWithRoot(w.Name)
+
+		var addBranch func(tree treeprint.Tree, node *BlockGraphNode)
+		addBranch = func(tree treeprint.Tree, node *BlockGraphNode) {
+			branch := tree.AddBranch(label(node))
+			for _, child := range node.Children {
+				addBranch(branch, child)
+			}
+		}
+		for _, root := range roots {
+			addBranch(tree, root)
+		}
+
+		fmt.Print(tree.String())
+	default:
+		return fmt.Errorf("unknown format: %s. Use one of tree, dot, json", format)
+	}
+	return nil
+}
+
+func (w *Workspace) ResolveWorkflows(tx *PolycrateTransaction) error {
+	names := map[string]bool{}
+
+	for i := 0; i < len(w.Workflows); i++ {
+		loadedWorkflow := w.Workflows[i]
+
+		if names[loadedWorkflow.Name] {
+			return fmt.Errorf("workflow '%s' is defined more than once", loadedWorkflow.Name)
+		}
+		names[loadedWorkflow.Name] = true
+
+		loadedWorkflow.workspace = w
+
+		// Loop over the steps
+		for _, loadedStep := range loadedWorkflow.getAllSteps() {
 			if err := loadedStep.validate(); err != nil {
 				return err
 			}
@@ -1773,7 +2512,51 @@ func (w *Workspace) ResolveWorkflows(tx *PolycrateTransaction) error {
 			return err
 		}
 
+		// Check the `needs` of all steps for unknown steps and cycles
+		if _, err := loadedWorkflow.ResolveStepGraph(); err != nil {
+			return err
+		}
+	}
+
+	// Check nested workflows for unknown workflows and recursion
+	visited := map[string]bool{}
+	for _, workflow := range w.Workflows {
+		if err := w.checkWorkflowRecursion(workflow, []string{}, visited); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Follows the nested workflow steps of a workflow depth-first
+// and returns an error if a workflow (directly or indirectly) runs itself
+func (w *Workspace) checkWorkflowRecursion(workflow *Workflow, path []string, visited map[string]bool) error {
+	for i, name := range path {
+		if name == workflow.Name {
+			cycle := append(path[i:], workflow.Name)
+			return fmt.Errorf("workflow '%s' runs itself: %s", workflow.Name, strings.Join(cycle, " -> "))
+		}
+	}
+	if visited[workflow.Name] {
+		return nil
+	}
+
+	path = append(path, workflow.Name)
+	for _, step := range workflow.getAllSteps() {
+		if step.RunWorkflow == "" {
+			continue
+		}
+
+		nested, err := w.GetWorkflow(step.RunWorkflow)
+		if err != nil {
+			return fmt.Errorf("step '%s' of workflow '%s' runs unknown workflow '%s'", step.Name, workflow.Name, step.RunWorkflow)
+		}
+		if err := w.checkWorkflowRecursion(nested, path, visited); err != nil {
+			return err
+		}
 	}
+
+	visited[workflow.Name] = true
 	return nil
 }
 
@@ -1896,7 +2679,12 @@ func (c *Workspace) bootstrapMounts() error {
 		c.registerMount(dockerSocket, dockerSocket)
 	}
 
-	for _, extraMount := range c.ExtraMounts {
+	return c.registerExtraMounts(c.ExtraMounts)
+}
+
+// Registers additional mounts in the format '/host:/container'
+func (c *Workspace) registerExtraMounts(extraMounts []string) error {
+	for _, extraMount := range extraMounts {
 		// Split by :
 		p := strings.Split(extraMount, ":")
 
@@ -1919,44 +2707,40 @@ func (c *Workspace) templateActionScripts() error {
 		snapshot := WorkspaceSnapshot{
 			Workspace: c,
 			Block:     block,
+			Steps:     c.stepResults,
+			Failure:   c.workflowFailure,
 		}
 
 		for _, action := range block.Actions {
-			//action := block.Actions[index]
-			// Loop over script of action
-			var newScript = []string{}
-			//printObject(snapshot)
-			if len(action.Script) > 0 {
-				// Loop over script slice and substitute the template
-				for _, scriptLine := range action.Script {
-					//log.Debug("Templating at snapshot generation, line: " + scriptLine)
-					t, err := template.New("script-line").Parse(scriptLine)
-					if err != nil {
-						return err
-					}
-
-					// Execute the template and save the substituted content to a var
-					var substitutedScriptLine bytes.Buffer
-					err = t.Execute(&substitutedScriptLine, snapshot)
-					if err != nil {
-						return err
-					}
-					//log.Debug("Substituted the following content: " + substitutedScriptLine.String())
-
-					//scriptLineStr := fmt.Sprintf("%v", scriptLine)
-					//scriptStrings = append(scriptStrings, scriptLineStr)
-					//log.Debugf("scriptLine as String: %s", scriptLineStr)
-					newScript = append(newScript, substitutedScriptLine.String())
-				}
-			}
-
 			// Update the action script with the substituted one
-			action.Script = newScript
+			if err := action.templateScript(snapshot); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
+// Substitutes the Go templates in the given lines with values from the snapshot
+func renderTemplates(lines []string, snapshot WorkspaceSnapshot) ([]string, error) {
+	rendered := []string{}
+	for _, line := range lines {
+		t, err := template.New("script-line").Parse(line)
+		if err != nil {
+			return nil, err
+		}
+
+		// Execute the template and save the substituted content to a var
+		var substitutedLine bytes.Buffer
+		err = t.Execute(&substitutedLine, snapshot)
+		if err != nil {
+			return nil, err
+		}
+		rendered = append(rendered, substitutedLine.String())
+	}
+	return rendered, nil
+}
+
 func (w *Workspace) DumpEnv() []string {
 	envVars := []string{}
 	for envVar := range w.env {
@@ -2034,7 +2818,15 @@ func (w *Workspace) bootstrapEnvVars() error {
 		w.registerEnvVar("POLYCRATE_WORKSPACE", workspace.ContainerPath)
 	}
 
-	for _, envVar := range w.ExtraEnv {
+	// Actions can write `key=value` lines to this file to pass outputs to later workflow steps
+	w.registerEnvVar("POLYCRATE_OUTPUT", w.getOutputPath())
+
+	return w.registerExtraEnv(w.ExtraEnv)
+}
+
+// Registers additional env vars in the format 'KEY=value'
+func (w *Workspace) registerExtraEnv(extraEnv []string) error {
+	for _, envVar := range extraEnv {
 		// Split by =
 		p := strings.Split(envVar, "=")
 
@@ -2048,6 +2840,55 @@ func (w *Workspace) bootstrapEnvVars() error {
 	return nil
 }
 
+func (w *Workspace) getOutputPath() string {
+	return filepath.Join(w.runtimeDir, "output")
+}
+
+// Returns the path the log of the last playbook run is copied to
+func (w *Workspace) getPlaybookLogPath() string {
+	return filepath.Join(w.runtimeDir, "playbook.log")
+}
+
+// Creates an empty output file and playbook log for the next action
+func (w *Workspace) resetOutput() error {
+	path := w.getOutputPath()
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.WriteFile(w.getPlaybookLogPath(), []byte{}, 0666); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte{}, 0666)
+}
+
+// Reads the `key=value` lines the last action wrote to the output file
+func (w *Workspace) readOutput() (map[string]string, error) {
+	data, err := os.ReadFile(w.getOutputPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	outputs := map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Split by the first =
+		p := strings.SplitN(line, "=", 2)
+		if len(p) != 2 || p[0] == "" {
+			return nil, fmt.Errorf("illegal output line found: %s", line)
+		}
+		outputs[strings.TrimSpace(p[0])] = p[1]
+	}
+	return outputs, nil
+}
+
 func (c *Workspace) GetSnapshot() WorkspaceSnapshot {
 	snapshot := WorkspaceSnapshot{
 		Workspace: c,
@@ -2057,11 +2898,48 @@ func (c *Workspace) GetSnapshot() WorkspaceSnapshot {
 		Step:      c.currentStep,
 		Env:       c.env,
 		Mounts:    c.mounts,
+		Steps:     c.stepResults,
+		Failure:   c.workflowFailure,
+		Inputs:    c.currentInputs,
 	}
 
 	return snapshot
 }
 
+// Evaluates a `when` expression against the snapshot
+// The expression is a Go template, e.g. `{{ eq .Block.Config.env "production" }}`
+// It's false if it renders to an empty string, "false", "no" or "0"
+func (s WorkspaceSnapshot) EvaluateCondition(expression string) (bool, error) {
+	funcs := template.FuncMap{
+		// Checks if a file or directory exists
+		// Relative paths are resolved against the workspace
+		"exists": func(path string) bool {
+			if !filepath.IsAbs(path) && s.Workspace != nil {
+				path = filepath.Join(s.Workspace.LocalPath, path)
+			}
+			_, err := os.Stat(path)
+			return err == nil
+		},
+	}
+
+	t, err := template.New("condition").Funcs(funcs).Option("missingkey=zero").Parse(expression)
+	if err != nil {
+		return false, err
+	}
+
+	var result bytes.Buffer
+	err = t.Execute(&result, s)
+	if err != nil {
+		return false, err
+	}
+
+	switch strings.ToLower(strings.TrimSpace(result.String())) {
+	case "", "false", "no", "0", "<no value>":
+		return false, nil
+	}
+	return true, nil
+}
+
 func (w *Workspace) SaveSnapshot(tx *PolycrateTransaction) (string, error) {
 	snapshot := w.GetSnapshot()
 
@@ -2130,7 +3008,7 @@ func (c *Workspace) registerCurrentBlock(block *Block) {
 		c.registerEnvVar("POLYCRATE_BLOCK_WORKDIR", block.Workdir.ContainerPath)
 	}
 	c.currentBlock = block
-	fmt.Printf("REGISTERED CURRENT BLOCK %s", block.Name)
+	log.Tracef("Registering current block: %s", block.Name)
 }
 func (c *Workspace) registerCurrentAction(action *Action) {
 
@@ -2142,6 +3020,30 @@ func (c *Workspace) registerCurrentWorkflow(workflow *Workflow) {
 	c.registerEnvVar("POLYCRATE_WORKFLOW", workflow.Name)
 	c.currentWorkflow = workflow
 }
+
+// Sets the values for the inputs of the next action (e.g. from --input)
+func (w *Workspace) SetInputs(inputs map[string]string) *Workspace {
+	w.inputs = inputs
+	return w
+}
+
+func (c *Workspace) registerCurrentInputs(action *Action, inputs map[string]interface{}) {
+	// Remove the inputs of a previous action
+	for envVar := range c.env {
+		if strings.HasPrefix(envVar, "POLYCRATE_INPUT_") {
+			delete(c.env, envVar)
+		}
+	}
+
+	for name, value := range inputs {
+		input, err := action.GetInput(name)
+		if err == nil {
+			c.registerEnvVar(input.getEnvVar(), fmt.Sprint(value))
+		}
+	}
+	c.currentInputs = inputs
+}
+
 func (c *Workspace) registerCurrentStep(step *Step) {
 
 	c.registerEnvVar("POLYCRATE_STEP", step.Name)
@@ -2208,6 +3110,48 @@ func (c *Workspace) getBlockByName(blockName string) *Block {
 	// }
 	//return nil
 }
+
+// Returns the blocks that have all of the given labels and are derived from the given block
+// Template blocks are never returned, neither is any block if no criteria are given
+func (w *Workspace) SelectBlocks(labels map[string]string, from string) []*Block {
+	fromName, _ := mapBlockName(from)
+
+	blocks := []*Block{}
+	if len(labels) == 0 && from == "" {
+		return blocks
+	}
+
+	for _, block := range w.Blocks {
+		if block.Template {
+			continue
+		}
+
+		if from != "" {
+			blockFromName, _ := mapBlockName(block.From)
+			if blockFromName != fromName {
+				continue
+			}
+		}
+
+		matches := true
+		for key, value := range labels {
+			if block.Labels[key] != value {
+				matches = false
+				break
+			}
+		}
+
+		if matches {
+			blocks = append(blocks, block)
+		}
+	}
+
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].Name < blocks[j].Name
+	})
+	return blocks
+}
+
 func (w *Workspace) GetLog(txid string) (*WorkspaceLog, error) {
 	for i := 0; i < len(w.logs); i++ {
 		log := w.logs[i]
@@ -2218,12 +3162,19 @@ func (w *Workspace) GetLog(txid string) (*WorkspaceLog, error) {
 	return nil, fmt.Errorf("log not found: %s", txid)
 }
 func (c *Workspace) GetBlock(name string) (*Block, error) {
+	// A block pinned to a digest must have been pulled from that digest
+	name, digest := splitDigest(name)
+
 	// Determine version from block string if any
 	_name, _version := mapBlockName(name)
 
 	for i := 0; i < len(c.Blocks); i++ {
 		block := c.Blocks[i]
 
+		if digest != "" && block.digest != digest {
+			continue
+		}
+
 		if block.Name == _name {
 			if _version != "" {
 				if block.Version == _version {
@@ -2602,21 +3553,75 @@ func (w *Workspace) IsBlockInstalled(fullTag string, registryUrl string, blockNa
 	return false
 }
 
-func (w *Workspace) UpdateBlocks(tx *PolycrateTransaction, args []string) error {
+// Pulls the given blocks (registry/name:version) and writes the resolved versions and digests to the lockfile
+// Versions can be semver ranges (e.g. ~1.2 or >=0.3 <0.4) that are resolved against the tags in the registry
+// Blocks in the lockfile are pulled at their locked version unless upgrade is true or their range changed
+func (w *Workspace) UpdateBlocks(tx *PolycrateTransaction, args []string, upgrade bool) error {
 
 	tx.Log.Infof("%d blocks to update", len(args))
 
+	lockfile, err := w.LoadLockfile()
+	if err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	locked := []WorkspaceLockfileBlock{}
+
 	eg := new(errgroup.Group)
 	for _, arg := range args {
 		arg := arg // https://go.dev/doc/faq#closures_and_goroutines
 		eg.Go(func() error {
-			fullTag, registryUrl, blockName, blockVersion := mapDockerTag(arg)
+			reference, pinned := splitDigest(arg)
+			_, registryUrl, blockName, constraint := mapDockerTag(reference)
+			repository := strings.Join([]string{registryUrl, blockName}, "/")
+
+			entry := lockfile.getBlock(repository)
+			if pinned != "" {
+				// Blocks pinned to a digest are pulled from that digest
+				entry = &WorkspaceLockfileBlock{
+					Name:       repository,
+					Constraint: constraint,
+					Version:    constraint,
+					Digest:     pinned,
+				}
+			} else if upgrade || entry == nil || entry.Constraint != constraint {
+				version, err := resolveBlockVersion(repository, constraint)
+				if err != nil {
+					return err
+				}
+				tx.Log.Debugf("Resolved block '%s:%s' to version %s", repository, constraint, version)
 
-			// Download blocks from registry
-			_, err := w.PullBlock(tx, fullTag, registryUrl, blockName, blockVersion)
-			if err != nil {
+				entry = &WorkspaceLockfileBlock{
+					Name:       repository,
+					Constraint: constraint,
+					Version:    version,
+				}
+			} else {
+				tx.Log.Debugf("Using locked version %s of block '%s'", entry.Version, repository)
+			}
+
+			// A re-pushed tag must not silently change a locked block
+			fullTag := strings.Join([]string{repository, entry.Version}, ":")
+			if pinned == "" {
+				digest, err := GetOCIDigest(fullTag)
+				if err != nil {
+					return err
+				}
+				if entry.Digest != "" && entry.Digest != digest {
+					return fmt.Errorf("block '%s' has changed in the registry: locked digest %s, got %s. Run `polycrate workspace update --upgrade` to accept the change", fullTag, entry.Digest, digest)
+				}
+				entry.Digest = digest
+			}
+
+			// Download blocks from registry by digest, so the tag can't change in the meantime
+			if _, err := w.PullBlock(tx, fullTag, registryUrl, blockName, entry.Version, entry.Digest); err != nil {
 				return err
 			}
+
+			mu.Lock()
+			locked = append(locked, *entry)
+			mu.Unlock()
 			return nil
 		})
 	}
@@ -2624,7 +3629,12 @@ func (w *Workspace) UpdateBlocks(tx *PolycrateTransaction, args []string) error
 	if err := eg.Wait(); err != nil {
 		return err
 	}
-	return nil
+
+	// Only the updated blocks change, the other dependencies stay pinned
+	for _, entry := range locked {
+		lockfile.setBlock(entry)
+	}
+	return w.SaveLockfile(tx, lockfile)
 }
 
 // - Accepts 1 arg: the block name/slug as it is in the registry
@@ -2644,7 +3654,9 @@ func (w *Workspace) UpdateBlocks(tx *PolycrateTransaction, args []string) error
 // 			return err
 // 		}
 
-func (w *Workspace) PullBlock(tx *PolycrateTransaction, fullTag string, registryUrl string, blockName string, blockVersion string) (*Block, error) {
+// Pulls the block from the registry into the workspace
+// If a digest is given, the block is pulled by digest instead of its version tag
+func (w *Workspace) PullBlock(tx *PolycrateTransaction, fullTag string, registryUrl string, blockName string, blockVersion string, digest string) (*Block, error) {
 	log := tx.Log.log
 	log = log.WithField("block", blockName)
 	log = log.WithField("version", blockVersion)
@@ -2660,9 +3672,8 @@ func (w *Workspace) PullBlock(tx *PolycrateTransaction, fullTag string, registry
 		log = log.WithField("path", block.Workdir.LocalPath)
 		log.Infof("Block is already installed")
 
-		if block.Version == blockVersion {
+		if (digest == "" && block.Version == blockVersion) || (digest != "" && block.digest == digest) {
 			return block, nil
-
 		}
 		log.Infof("Installed version differs from requested version")
 	}
@@ -2672,12 +3683,50 @@ func (w *Workspace) PullBlock(tx *PolycrateTransaction, fullTag string, registry
 	//log.Debugf("Pulling block %s:%s", blockName, blockVersion)
 	log = log.WithField("path", targetDir)
 
+	// Only blocks signed by trusted keys may be pulled
+	digest, err = w.verifyBlockSignature(tx, registryUrl, blockName, blockVersion, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	reference := blockVersion
+	if digest != "" {
+		log = log.WithField("digest", digest)
+		reference = digest
+	}
+
 	log.Infof("Pulling block")
 
-	err = UnwrapOCIImage(tx.Context, targetDir, registryUrl, blockName, blockVersion)
+	pulledDigest, err := UnwrapOCIImage(tx.Context, targetDir, registryUrl, blockName, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record what has been pulled, so modifications of the block can be detected
+	manifest, err := NewBlockManifest(targetDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// The pulled content must match the checksum the block has been pushed with
+	labels, err := GetOCILabels(strings.Join([]string{registryUrl, strings.Join([]string{blockName, pulledDigest}, "@")}, "/"))
 	if err != nil {
 		return nil, err
 	}
+	if err := verifyPushedChecksum(tx, fullTag, manifest, labels, w.Config.VerifyBlocks); err != nil {
+		return nil, err
+	}
+	metadata := &BlockMetadata{
+		Reference:    fullTag,
+		Digest:       pulledDigest,
+		Checksum:     manifest.Checksum(),
+		ChecksumAlgo: manifest.Algorithm,
+		Manifest:     manifest,
+		Date:         time.Now().Format(time.RFC3339),
+	}
+	if err := saveBlockMetadata(targetDir, metadata); err != nil {
+		return nil, err
+	}
 
 	// Load Block
 	//var block Block
@@ -2731,8 +3780,8 @@ func (c *Workspace) PushBlock(tx *PolycrateTransaction, blockName string) error
 	}
 
 	// Add Checksum to Labels
-	block.Labels["polycrate.blocks.checksum"] = block.Checksum
-	block.Labels["polycrate.blocks.checksum_algo"] = "md5"
+	block.Labels[BlocksChecksumLabel] = block.Checksum
+	block.Labels[BlocksChecksumAlgoLabel] = BlockChecksumAlgorithmSHA256
 
 	if dev {
 		// Append "-dev" to tag
@@ -2745,13 +3794,158 @@ func (c *Workspace) PushBlock(tx *PolycrateTransaction, blockName string) error
 
 	block.Labels["polycrate.block.version"] = block.Version
 
-	err = WrapOCIImage(tx.Context, block.Workdir.LocalPath, registryUrl, blockName, tagVersion, block.Labels)
+	var signer ssh.Signer
+	if signBlock {
+		signer, err = loadSigningKey(getSigningKeyPath())
+		if err != nil {
+			return err
+		}
+		log = log.WithField("key", ssh.FingerprintSHA256(signer.PublicKey()))
+		log.Infof("Signing block")
+	}
+
+	err = WrapOCIImage(tx.Context, block.Workdir.LocalPath, registryUrl, blockName, tagVersion, block.Labels, signer)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// Signs a block that has been pushed to the registry already
+func (w *Workspace) SignBlock(tx *PolycrateTransaction, registryUrl string, blockName string, blockVersion string, digest string) error {
+	log := tx.Log.log
+	log = log.WithField("block", blockName)
+	log = log.WithField("version", blockVersion)
+	log = log.WithField("registry", registryUrl)
+
+	signer, err := loadSigningKey(getSigningKeyPath())
+	if err != nil {
+		return err
+	}
+	log = log.WithField("key", ssh.FingerprintSHA256(signer.PublicKey()))
+
+	reference := blockVersion
+	if digest != "" {
+		reference = digest
+	}
+	ref, err := getOCIReference(registryUrl, blockName, reference)
+	if err != nil {
+		return err
+	}
+
+	if digest == "" {
+		digest, err = GetOCIDigest(ref.String())
+		if err != nil {
+			return err
+		}
+	}
+	log = log.WithField("digest", digest)
+
+	log.Infof("Signing block")
+	return SignOCIImage(tx.Context, ref.Context(), digest, signer)
+}
+
+// Verifies the signature of the block according to the trust policy of the workspace
+// Returns the digest that has been verified, so exactly that image is pulled
+func (w *Workspace) verifyBlockSignature(tx *PolycrateTransaction, registryUrl string, blockName string, blockVersion string, digest string) (string, error) {
+	policy := w.getTrustPolicy()
+	if policy.Mode == TrustPolicyOff {
+		return digest, nil
+	}
+
+	reference := blockVersion
+	if digest != "" {
+		reference = digest
+	}
+	ref, err := getOCIReference(registryUrl, blockName, reference)
+	if err != nil {
+		return "", err
+	}
+
+	if digest == "" {
+		digest, err = GetOCIDigest(ref.String())
+		if err != nil {
+			return "", err
+		}
+	}
+
+	fingerprint, err := VerifyOCIImage(tx.Context, ref.Context(), digest, policy.Keys)
+	if err != nil {
+		if policy.Mode == TrustPolicyEnforce {
+			return "", fmt.Errorf("refusing to pull block: %s", err)
+		}
+		tx.Log.Warn(err)
+		return digest, nil
+	}
+
+	tx.Log.Debugf("Block %s@%s is signed with trusted key %s", ref.Context().Name(), digest, fingerprint)
+	return digest, nil
+}
+
+// Reports the files of pulled blocks that have been modified since they have been pulled
+// Fails if any block has been modified
+func (w *Workspace) VerifyBlocks(tx *PolycrateTransaction, args []string) error {
+	blocks := w.installedBlocks
+	if len(args) > 0 {
+		blocks = []*Block{}
+		for _, blockName := range args {
+			block, err := w.GetBlock(blockName)
+			if err != nil {
+				return err
+			}
+			if block.metadata == nil {
+				return fmt.Errorf("block '%s' has not been pulled from a registry", block.Name)
+			}
+			blocks = append(blocks, block)
+		}
+	}
+
+	modified := []string{}
+	for _, block := range blocks {
+		if block.metadata == nil {
+			continue
+		}
+
+		diff, err := block.getModifications(tx, block.metadata)
+		if err != nil {
+			return err
+		}
+		if diff == nil {
+			tx.Log.Warnf("Block '%s' can't be verified: it has been pulled to '%s' with an md5 checksum. Pull it again to verify it", block.Name, block.metadata.Path)
+			continue
+		}
+		if diff.Empty() {
+			fmt.Printf("%s: ok\n", block.Name)
+			continue
+		}
+
+		modified = append(modified, block.Name)
+		fmt.Printf("%s: modified since it has been pulled from %s (digest %s)\n", block.Name, block.metadata.Reference, block.metadata.Digest)
+		if block.metadata.Manifest == nil {
+			fmt.Printf("  the block has been pulled with an md5 checksum; pull it again to list the modified files\n")
+			continue
+		}
+		for _, c := range []struct {
+			name  string
+			files []string
+		}{
+			{"modified", diff.Modified},
+			{"added", diff.Added},
+			{"removed", diff.Removed},
+			{"mode changed", diff.ModeChanged},
+		} {
+			for _, file := range c.files {
+				fmt.Printf("  %s: %s\n", c.name, file)
+			}
+		}
+	}
+
+	if len(modified) > 0 {
+		return fmt.Errorf("blocks have been modified: %s", strings.Join(modified, ", "))
+	}
+	return nil
+}
+
 func (c *Workspace) UninstallBlocks(tx *PolycrateTransaction, args []string) error {
 	for _, blockName := range args {
 		block, err := c.GetBlock(blockName)
@@ -3016,10 +4210,28 @@ func (w *Workspace) LoadBlock(tx *PolycrateTransaction, path string) (*Block, er
 		block.Workdir.Path = block.Workdir.ContainerPath
 	}
 
-	block.Checksum, err = hashdir.Make(block.Workdir.LocalPath, "md5")
+	block.manifest, err = NewBlockManifest(block.Workdir.LocalPath)
 	if err != nil {
 		return nil, err
 	}
+	block.Checksum = block.manifest.Checksum()
+
+	// Pulled blocks must not have been modified
+	metadata, err := loadBlockMetadata(block.Workdir.LocalPath)
+	if err != nil {
+		return nil, err
+	}
+	if metadata != nil {
+		block.digest = metadata.Digest
+		block.metadata = metadata
+
+		// blocks verify reports the modifications itself
+		if !verifyingBlocks {
+			if err := block.verifyMetadata(tx, metadata, w.Config.VerifyBlocks); err != nil {
+				return nil, err
+			}
+		}
+	}
 
 	block.workspace = w
 	return block, nil
@@ -3116,6 +4328,56 @@ func (w *Workspace) FindInstalledBlocks(tx *PolycrateTransaction, path string) e
 
 	return nil
 }
+func (w *Workspace) FindWorkflows(tx *PolycrateTransaction, path string) error {
+	workflowsDir := path
+	tx.Log.Debugf("Searching for workflows at %s", path)
+
+	if _, err := os.Stat(workflowsDir); os.IsNotExist(err) {
+		log := tx.Log.log.WithField("path", workflowsDir)
+		log.Debugf("Skipping workflow discovery. Workflows directory not found")
+		return nil
+	}
+
+	return filepath.WalkDir(workflowsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || filepath.Ext(path) != ".poly" {
+			return nil
+		}
+
+		workflow, err := w.LoadWorkflow(tx, path)
+		if err != nil {
+			return err
+		}
+
+		if existingWorkflow, err := w.GetWorkflow(workflow.Name); err == nil {
+			source := WorkspaceConfigFile
+			if existingWorkflow.path != "" {
+				source = existingWorkflow.path
+			}
+			return fmt.Errorf("workflow '%s' in %s is already defined in %s", workflow.Name, path, source)
+		}
+
+		tx.Log.Debugf("Found workflow '%s' at %s", workflow.Name, path)
+		w.Workflows = append(w.Workflows, workflow)
+		return nil
+	})
+}
+
+func (w *Workspace) LoadWorkflow(tx *PolycrateTransaction, path string) (*Workflow, error) {
+	workflow := new(Workflow)
+
+	if _, err := loadYAMLFile(path, workflow); err != nil {
+		return nil, fmt.Errorf("failed to load workflow from %s: %w", path, err)
+	}
+
+	workflow.path = path
+
+	return workflow, nil
+}
+
 func (w *Workspace) FindLogs(tx *PolycrateTransaction, path string) error {
 	logsDir := path
 	tx.Log.Debugf("Searching for logs at %s", path)
@@ -3131,6 +4393,11 @@ func (w *Workspace) FindLogs(tx *PolycrateTransaction, path string) error {
 				return err
 			}
 
+			// The progress of workflow runs and the cached runs of actions are not logs
+			if d.IsDir() && (path == filepath.Join(logsDir, WorkspaceWorkflowRunsDir) || path == filepath.Join(logsDir, WorkspaceCacheDir)) {
+				return filepath.SkipDir
+			}
+
 			if !d.IsDir() {
 				if filepath.Ext(path) == ".yml" {
 					log, err := w.LoadLog(tx, path)
@@ -0,0 +1,574 @@
+/*
+Copyright © 2021 Fabian Peter <fp@ayedo.de>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEvaluateCondition(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "flag"), []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := WorkspaceSnapshot{
+		Workspace: &Workspace{LocalPath: dir},
+		Block: &Block{
+			Name:   "app",
+			Config: map[interface{}]interface{}{"env": "production", "replicas": 3},
+		},
+		Env: map[string]string{"CI": "true"},
+		Steps: map[string]*StepResult{
+			"build": {Status: StepStatusSucceeded, Outputs: map[string]string{"image": "app:1.0"}},
+		},
+	}
+
+	tests := []struct {
+		expression string
+		expected   bool
+		err        bool
+	}{
+		{expression: `{{ eq .Block.Config.env "production" }}`, expected: true},
+		{expression: `{{ eq .Block.Config.env "staging" }}`, expected: false},
+		{expression: `{{ gt .Block.Config.replicas 1 }}`, expected: true},
+		{expression: `{{ .Env.CI }}`, expected: true},
+		{expression: `{{ .Env.MISSING }}`, expected: false},
+		{expression: `{{ .Block.Config.missing }}`, expected: false},
+		{expression: `{{ (index .Steps "build").Outputs.image }}`, expected: true},
+		{expression: `{{ eq (index .Steps "build").Status "succeeded" }}`, expected: true},
+		{expression: `{{ exists "flag" }}`, expected: true},
+		{expression: `{{ exists "missing" }}`, expected: false},
+		{expression: `{{ exists "` + filepath.Join(dir, "flag") + `" }}`, expected: true},
+		{expression: `true`, expected: true},
+		{expression: `yes`, expected: true},
+		{expression: `False`, expected: false},
+		{expression: `no`, expected: false},
+		{expression: `0`, expected: false},
+		{expression: ` `, expected: false},
+		{expression: `{{ if`, err: true},
+		{expression: `{{ .Block.Missing }}`, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			result, err := snapshot.EvaluateCondition(tt.expression)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %t", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected %t, got %t", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestReadOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  *string
+		expected map[string]string
+		err      bool
+	}{
+		{
+			name:     "missing output file",
+			expected: nil,
+		},
+		{
+			name:     "empty output file",
+			content:  stringPtr(""),
+			expected: map[string]string{},
+		},
+		{
+			name:    "key value lines",
+			content: stringPtr("image=app:1.0\n\n# comment\n  replicas = 3\nurl=https://example.com/?a=b\nempty=\n"),
+			expected: map[string]string{
+				"image":    "app:1.0",
+				"replicas": " 3",
+				"url":      "https://example.com/?a=b",
+				"empty":    "",
+			},
+		},
+		{
+			name:     "later lines override earlier ones",
+			content:  stringPtr("version=1\nversion=2\n"),
+			expected: map[string]string{"version": "2"},
+		},
+		{
+			name:    "line without separator",
+			content: stringPtr("image=app:1.0\ninvalid\n"),
+			err:     true,
+		},
+		{
+			name:    "line without key",
+			content: stringPtr("=value\n"),
+			err:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &Workspace{runtimeDir: t.TempDir()}
+			if tt.content != nil {
+				if err := os.WriteFile(w.getOutputPath(), []byte(*tt.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			outputs, err := w.readOutput()
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", outputs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(outputs, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, outputs)
+			}
+		})
+	}
+}
+
+func TestResetOutput(t *testing.T) {
+	w := &Workspace{runtimeDir: filepath.Join(t.TempDir(), "runtime")}
+	if err := w.resetOutput(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(w.getOutputPath(), []byte("key=value\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next action starts with an empty output file
+	if err := w.resetOutput(); err != nil {
+		t.Fatal(err)
+	}
+	outputs, err := w.readOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outputs) != 0 {
+		t.Errorf("expected no outputs after reset, got %v", outputs)
+	}
+}
+
+func TestResolveActionDependencies(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions []*Action
+		order   []string
+		// The actions that are skipped if they are up to date
+		dependencies []string
+		err          string
+	}{
+		{
+			name:    "action without dependencies",
+			actions: []*Action{{Name: "deploy", Block: "app"}},
+			order:   []string{"app:deploy"},
+		},
+		{
+			name: "dependencies, pre and post hooks",
+			actions: []*Action{
+				{Name: "deploy", Block: "app", DependsOn: []string{"db:migrate"}, Pre: []string{"check"}, Post: []string{"notify"}},
+				{Name: "check", Block: "app"},
+				{Name: "notify", Block: "app"},
+				{Name: "migrate", Block: "db", DependsOn: []string{"backup"}},
+				{Name: "backup", Block: "db"},
+			},
+			order:        []string{"db:backup", "db:migrate", "app:check", "app:deploy", "app:notify"},
+			dependencies: []string{"db:backup", "db:migrate"},
+		},
+		{
+			name: "shared dependencies run once",
+			actions: []*Action{
+				{Name: "deploy", Block: "app", DependsOn: []string{"build", "test"}},
+				{Name: "test", Block: "app", DependsOn: []string{"build"}},
+				{Name: "build", Block: "app"},
+			},
+			order:        []string{"app:build", "app:test", "app:deploy"},
+			dependencies: []string{"app:build", "app:test"},
+		},
+		{
+			name: "post hook depending on the action",
+			actions: []*Action{
+				{Name: "deploy", Block: "app", Post: []string{"verify"}},
+				{Name: "verify", Block: "app", DependsOn: []string{"deploy"}},
+			},
+			order:        []string{"app:deploy", "app:verify"},
+			dependencies: []string{"app:deploy"},
+		},
+		{
+			name: "action depending on itself",
+			actions: []*Action{
+				{Name: "deploy", Block: "app", DependsOn: []string{"deploy"}},
+			},
+			err: "action 'app:deploy' depends on itself: app:deploy -> app:deploy",
+		},
+		{
+			name: "cycle across blocks",
+			actions: []*Action{
+				{Name: "deploy", Block: "app", DependsOn: []string{"db:migrate"}},
+				{Name: "migrate", Block: "db", Pre: []string{"app:deploy"}},
+			},
+			err: "action 'app:deploy' depends on itself: app:deploy -> db:migrate -> app:deploy",
+		},
+		{
+			name: "unknown action",
+			actions: []*Action{
+				{Name: "deploy", Block: "app", DependsOn: []string{"build"}},
+			},
+			err: "action 'deploy' of block 'app' refers to unknown action 'build'",
+		},
+		{
+			name: "unknown block",
+			actions: []*Action{
+				{Name: "deploy", Block: "app", DependsOn: []string{"db:migrate"}},
+			},
+			err: "action 'deploy' of block 'app' refers to unknown block 'db'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &Workspace{}
+			blocks := map[string]*Block{}
+			for _, action := range tt.actions {
+				block, ok := blocks[action.Block]
+				if !ok {
+					block = &Block{Name: action.Block}
+					blocks[action.Block] = block
+					w.Blocks = append(w.Blocks, block)
+				}
+				block.Actions = append(block.Actions, action)
+			}
+
+			actions, err := w.ResolveActionDependencies(tt.actions[0])
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			order := []string{}
+			for _, action := range actions {
+				order = append(order, action.getAddress())
+			}
+			if strings.Join(order, ",") != strings.Join(tt.order, ",") {
+				t.Errorf("expected order %v, got %v", tt.order, order)
+			}
+
+			dependencies := []string{}
+			isDependency := w.getDependencies(actions)
+			for _, action := range actions {
+				if isDependency[action] {
+					dependencies = append(dependencies, action.getAddress())
+				}
+			}
+			if strings.Join(dependencies, ",") != strings.Join(tt.dependencies, ",") {
+				t.Errorf("expected dependencies %v, got %v", tt.dependencies, dependencies)
+			}
+		})
+	}
+}
+
+func TestActionCache(t *testing.T) {
+	tx := newTestTransaction()
+	defer tx.CancelFunc()
+
+	w := &Workspace{LocalPath: t.TempDir()}
+	w.Config.LogsRoot = "logs"
+	action := &Action{Name: "deploy", Block: "app"}
+	other := &Action{Name: "build", Block: "app"}
+
+	if cache := w.getLastCachedRun(action); cache != nil {
+		t.Fatalf("expected no cached run, got %+v", cache)
+	}
+
+	// Runs recorded in the workspace logs are used if there's no cache file
+	w.logs = []*WorkspaceLog{
+		{PolycrateEvent: PolycrateEvent{Transaction: "one", Date: "2024-01-01T00:00:00Z", Block: "app", Action: "deploy", CacheKeys: map[string]string{"app:deploy": "old"}}},
+		{PolycrateEvent: PolycrateEvent{Transaction: "two", Date: "2024-01-02T00:00:00Z", Block: "app", Action: "deploy", CacheKeys: map[string]string{"app:deploy": "new"}, Outputs: map[string]string{"url": "a"}}},
+		{PolycrateEvent: PolycrateEvent{Transaction: "three", Date: "2024-01-03T00:00:00Z", Block: "app", Action: "release", CacheKeys: map[string]string{"app:build": "dep"}, Outputs: map[string]string{"url": "b"}}},
+	}
+	if cache := w.getLastCachedRun(action); cache == nil || cache.Key != "new" || cache.Transaction != "two" || cache.Outputs["url"] != "a" {
+		t.Errorf("expected the latest run from the logs, got %+v", cache)
+	}
+	// The outputs of a transaction only belong to its own action
+	if cache := w.getLastCachedRun(other); cache == nil || cache.Key != "dep" || cache.Outputs != nil {
+		t.Errorf("expected the dependency run without outputs, got %+v", cache)
+	}
+
+	// The cache file takes precedence and doesn't need the logs
+	tx.SetOutputs(map[string]string{"url": "c"})
+	w.saveCachedRun(tx, action, "saved")
+	w.logs = nil
+
+	cache := w.getLastCachedRun(action)
+	if cache == nil || cache.Key != "saved" || cache.Transaction != tx.TXID.String() || cache.Outputs["url"] != "c" {
+		t.Errorf("expected the saved run, got %+v", cache)
+	}
+	if tx.CacheKeys["app:deploy"] != "saved" {
+		t.Errorf("expected the cache key to be recorded in the transaction, got %v", tx.CacheKeys)
+	}
+	if cache := w.getLastCachedRun(other); cache != nil {
+		t.Errorf("expected no cached run of another action, got %+v", cache)
+	}
+}
+
+func TestResolveBlockGraph(t *testing.T) {
+	tests := []struct {
+		name   string
+		blocks []*Block
+		order  []string
+		err    string
+	}{
+		{
+			name:   "blocks without parents keep their order",
+			blocks: []*Block{{Name: "aa"}, {Name: "bb"}},
+			order:  []string{"aa", "bb"},
+		},
+		{
+			name: "parents come before their children",
+			blocks: []*Block{
+				{Name: "app", From: "k8s-app"},
+				{Name: "k8s-app", From: "base"},
+				{Name: "db", From: "base"},
+				{Name: "base"},
+			},
+			order: []string{"base", "k8s-app", "app", "db"},
+		},
+		{
+			name: "parent pinned to a version",
+			blocks: []*Block{
+				{Name: "app", From: "base:1.1.0"},
+				{Name: "base", Version: "1.1.0"},
+			},
+			order: []string{"base", "app"},
+		},
+		{
+			name: "all missing parents are reported",
+			blocks: []*Block{
+				{Name: "app", From: "k8s-app"},
+				{Name: "db", From: "base:2.0.0"},
+				{Name: "base", Version: "1.0.0"},
+			},
+			err: "dependencies not found in the Workspace: 'k8s-app' (from block 'app'), 'base:2.0.0' (from block 'db')",
+		},
+		{
+			name: "block inheriting from itself",
+			blocks: []*Block{
+				{Name: "app", From: "app"},
+			},
+			err: "block 'app' inherits from itself: app -> app",
+		},
+		{
+			name: "cycle",
+			blocks: []*Block{
+				{Name: "base"},
+				{Name: "aa", From: "cc"},
+				{Name: "bb", From: "aa"},
+				{Name: "cc", From: "bb"},
+			},
+			err: "block 'aa' inherits from itself: aa -> cc -> bb -> aa",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &Workspace{Blocks: tt.blocks}
+
+			order, err := w.ResolveBlockGraph()
+			if tt.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			names := []string{}
+			for _, block := range order {
+				names = append(names, block.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.order, ",") {
+				t.Errorf("expected order %v, got %v", tt.order, names)
+			}
+		})
+	}
+}
+
+func TestGetBlockGraph(t *testing.T) {
+	w := &Workspace{Blocks: []*Block{
+		{Name: "db", From: "base"},
+		{Name: "app", From: "k8s-app"},
+		{Name: "k8s-app", From: "base", Template: true},
+		{Name: "base", Version: "1.0.0", Template: true},
+		{Name: "standalone"},
+	}}
+
+	roots, err := w.GetBlockGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*BlockGraphNode{
+		{Name: "base", Version: "1.0.0", Template: true, Children: []*BlockGraphNode{
+			{Name: "db", From: "base"},
+			{Name: "k8s-app", From: "base", Template: true, Children: []*BlockGraphNode{
+				{Name: "app", From: "k8s-app"},
+			}},
+		}},
+		{Name: "standalone"},
+	}
+	if !reflect.DeepEqual(roots, expected) {
+		t.Errorf("unexpected graph: %s", mustJSON(t, roots))
+	}
+}
+
+func TestSelectBlocks(t *testing.T) {
+	w := &Workspace{Blocks: []*Block{
+		{Name: "k8s-app", Template: true, Labels: map[string]string{"tier": "web"}},
+		{Name: "web", From: "k8s-app:1.0.0", Labels: map[string]string{"tier": "web", "env": "prod"}},
+		{Name: "api", From: "k8s-app", Labels: map[string]string{"tier": "web", "env": "dev"}},
+		{Name: "db", Labels: map[string]string{"tier": "data", "env": "prod"}},
+	}}
+
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		from     string
+		expected []string
+	}{
+		{name: "no criteria", expected: []string{}},
+		{name: "empty labels", labels: map[string]string{}, expected: []string{}},
+		{name: "label", labels: map[string]string{"tier": "web"}, expected: []string{"api", "web"}},
+		{name: "all labels must match", labels: map[string]string{"tier": "web", "env": "prod"}, expected: []string{"web"}},
+		{name: "from ignores versions", from: "k8s-app:2.0.0", expected: []string{"api", "web"}},
+		{name: "from and labels", from: "k8s-app", labels: map[string]string{"env": "dev"}, expected: []string{"api"}},
+		{name: "nothing matches", labels: map[string]string{"tier": "cache"}, expected: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names := []string{}
+			for _, block := range w.SelectBlocks(tt.labels, tt.from) {
+				names = append(names, block.Name)
+			}
+			if !reflect.DeepEqual(names, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, names)
+			}
+		})
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestGetContainerImage(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "Dockerfile.poly"), []byte("FROM scratch\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		config     ContainerConfig
+		dockerfile string
+		build      bool
+		expected   string
+	}{
+		{
+			name:     "workspace image",
+			expected: "cargo.ayedo.cloud/library/polycrate:0.1.0",
+		},
+		{
+			name:     "image of the action",
+			config:   ContainerConfig{Image: "alpine:3.20"},
+			expected: "alpine:3.20",
+		},
+		{
+			name:     "dockerfile of the action without --build",
+			config:   ContainerConfig{Dockerfile: "Dockerfile", context: "/blocks/app"},
+			expected: "ws-app:" + version,
+		},
+		{
+			name:     "dockerfile of the action with --build",
+			config:   ContainerConfig{Dockerfile: "Dockerfile", context: "/blocks/app"},
+			build:    true,
+			expected: "ws-app:" + version,
+		},
+		{
+			name:       "dockerfile of the workspace without --build",
+			dockerfile: "Dockerfile.poly",
+			expected:   "cargo.ayedo.cloud/library/polycrate:0.1.0",
+		},
+		{
+			name:       "dockerfile of the workspace with --build",
+			dockerfile: "Dockerfile.poly",
+			build:      true,
+			expected:   "ws:" + version,
+		},
+		{
+			name:       "missing dockerfile of the workspace",
+			dockerfile: "Dockerfile.missing",
+			build:      true,
+			expected:   "cargo.ayedo.cloud/library/polycrate:0.1.0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(b bool) { build = b }(build)
+			build = tt.build
+
+			w := &Workspace{Name: "ws", LocalPath: dir}
+			w.Config.Image.Reference = "cargo.ayedo.cloud/library/polycrate"
+			w.Config.Image.Version = "0.1.0"
+			w.Config.Dockerfile = tt.dockerfile
+
+			if image := w.getContainerImage(tt.config); image != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, image)
+			}
+		})
+	}
+}
@@ -20,6 +20,8 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var upgradeBlocks bool
+
 // installCmd represents the install command
 var workspaceUpdateCmd = &cobra.Command{
 	Use:   "update",
@@ -39,7 +41,7 @@ var workspaceUpdateCmd = &cobra.Command{
 			tx.Log.Fatal(err)
 		}
 
-		err = workspace.UpdateBlocks(tx, workspace.Dependencies)
+		err = workspace.UpdateBlocks(tx, workspace.Dependencies, upgradeBlocks)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -47,5 +49,7 @@ var workspaceUpdateCmd = &cobra.Command{
 }
 
 func init() {
+	workspaceUpdateCmd.Flags().BoolVar(&upgradeBlocks, "upgrade", false, "Resolve the versions of all dependencies again instead of using the versions in the lockfile")
+
 	workspaceCmd.AddCommand(workspaceUpdateCmd)
 }